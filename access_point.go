@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"strconv"
+	"strings"
 
 	ini "gopkg.in/ini.v1"
 )
@@ -39,6 +40,43 @@ type AccessPoint struct {
 	SSID            string  `ini:"SSID" json:"ssid"`
 	Passphrase      string  `ini:"PASSPHRASE" json:"passphrase"`
 	UsePsk          int     `ini:"USE_PSK" json:"use_psk"`
+
+	// Backend and Firewall are not create_ap settings, they select how fconf
+	// runs the access point.
+	Backend  string `ini:"-" json:"backend,omitempty"`
+	Firewall string `ini:"-" json:"firewall,omitempty"`
+
+	// AllowedMACs are written to MACFilterAccept.
+	AllowedMACs []string `ini:"-" json:"allowed_macs,omitempty"`
+
+	// Concurrent is true when the access point shares the radio with a wifi
+	// client. With the hostapd backend fconf creates VirtIface for the access
+	// point, create_ap does that on its own.
+	Concurrent bool   `ini:"-" json:"concurrent,omitempty"`
+	VirtIface  string `ini:"-" json:"virtual_interface,omitempty"`
+
+	// clientChannel is true when Channel is the one of the network the wifi
+	// client is connected to, the regulatory checks are left to the client.
+	clientChannel bool `ini:"-"`
+
+	// Portal is the captive portal served to new clients, nil when it is
+	// off.
+	Portal *CaptivePortal `ini:"-" json:"portal,omitempty"`
+
+	// Schedule are the weekly windows during which the access point is on.
+	Schedule []*APWindow `ini:"-" json:"schedule,omitempty"`
+
+	// Limits are the bandwidth limits of the clients, nil when there are
+	// none.
+	Limits *APLimits `ini:"-" json:"limits,omitempty"`
+}
+
+// apIface returns the interface the access point runs on.
+func (a *AccessPoint) apIface() string {
+	if a.VirtIface != "" {
+		return a.VirtIface
+	}
+	return a.WifiIface
 }
 
 //LoadAPFromSrc loads access point configuration fom [byte
@@ -92,6 +130,55 @@ type AccessPointConfig struct {
 	Passphrase     string `json:"passphrase"`
 	Gateway        string `json:"gateway"`
 	ShareInterfaec string `json:"shared_interface"`
+
+	// Backend is either create_ap or hostapd, defaults to create_ap.
+	Backend string `json:"backend,omitempty"`
+
+	// Firewall is used to setup NAT with the hostapd backend, either
+	// iptables or nftables. Defaults to iptables.
+	Firewall string `json:"firewall,omitempty"`
+
+	// The fields below map to the create_ap settings of the same name. When
+	// they are not set the values from DefaultAccesPoint are used.
+	ShareMethod     string  `json:"share_method,omitempty"`
+	Country         string  `json:"country,omitempty"`
+	FreqBand        float64 `json:"freq_band,omitempty"`
+	IEEE80211N      *bool   `json:"ieee80211n,omitempty"`
+	IEEE80211AC     *bool   `json:"ieee80211ac,omitempty"`
+	HTCapAb         *string `json:"ht_capab,omitempty"`
+	VHTCapAb        *string `json:"vht_capab,omitempty"`
+	WPAVersion      int     `json:"wpa_version,omitempty"`
+	UsePsk          *bool   `json:"use_psk,omitempty"`
+	IsolateClients  *bool   `json:"isolate_clients,omitempty"`
+	MACFilter       *bool   `json:"mac_filter,omitempty"`
+	MACFilterAccept string  `json:"mac_filter_accept,omitempty"`
+	Driver          string  `json:"driver,omitempty"`
+	NoVirt          *bool   `json:"no_virt,omitempty"`
+	NewMACAddr      *string `json:"new_macaddr,omitempty"`
+	DHCPDNS         string  `json:"dhcp_dns,omitempty"`
+	NoDNS           *bool   `json:"no_dns,omitempty"`
+	ETCHosts        *bool   `json:"etc_hosts,omitempty"`
+	NoHaveGED       *bool   `json:"no_haveged,omitempty"`
+
+	// AllowedMACs are the stations allowed to connect when mac_filter is on.
+	AllowedMACs []string `json:"allowed_macs,omitempty"`
+
+	// Concurrent runs the access point next to a wifi client on the same
+	// radio. It is turned on when a wifi client is configured on interface.
+	Concurrent       bool   `json:"concurrent,omitempty"`
+	VirtualInterface string `json:"virtual_interface,omitempty"`
+
+	// Portal turns on the captive portal, new clients are redirected to a
+	// landing page until they accept it.
+	Portal *CaptivePortal `json:"portal,omitempty"`
+
+	// Schedule turns the access point on only during these weekly windows.
+	// It is always on when the schedule is empty.
+	Schedule []*APWindow `json:"schedule,omitempty"`
+
+	// Limits are the bandwidth limits of the clients, applied with tc on the
+	// access point interface.
+	Limits *APLimits `json:"limits,omitempty"`
 }
 
 func (a *AccessPoint) Update(ap *AccessPointConfig) {
@@ -102,6 +189,9 @@ func (a *AccessPoint) Update(ap *AccessPointConfig) {
 		a.ShareMethod = "none"
 		a.InternetIface = ""
 	}
+	if ap.ShareMethod != "" {
+		a.ShareMethod = ap.ShareMethod
+	}
 	if ap.SSID != "" {
 		a.SSID = ap.SSID
 		a.Passphrase = ap.Passphrase
@@ -120,6 +210,81 @@ func (a *AccessPoint) Update(ap *AccessPointConfig) {
 	if ap.Interface != "" {
 		a.WifiIface = ap.Interface
 	}
+	if ap.Backend != "" {
+		a.Backend = ap.Backend
+	}
+	if ap.Firewall != "" {
+		a.Firewall = ap.Firewall
+	}
+	if ap.Country != "" {
+		a.Country = strings.ToUpper(ap.Country)
+	}
+	if ap.FreqBand != 0 {
+		a.FreqBand = ap.FreqBand
+	}
+	if ap.WPAVersion != 0 {
+		a.WPAVersion = ap.WPAVersion
+	}
+	if ap.MACFilterAccept != "" {
+		a.MACFilterAccept = ap.MACFilterAccept
+	}
+	if ap.Driver != "" {
+		a.Driver = ap.Driver
+	}
+	if ap.DHCPDNS != "" {
+		a.DHCPDNS = ap.DHCPDNS
+	}
+	if ap.HTCapAb != nil {
+		a.HTCapAb = *ap.HTCapAb
+	}
+	if ap.VHTCapAb != nil {
+		a.VHTCapAb = *ap.VHTCapAb
+	}
+	if ap.NewMACAddr != nil {
+		a.NewMACAddr = *ap.NewMACAddr
+	}
+	if ap.AllowedMACs != nil {
+		a.AllowedMACs = ap.AllowedMACs
+	}
+	a.Concurrent = ap.Concurrent
+	a.VirtIface = ap.VirtualInterface
+	a.Portal = ap.Portal
+	a.Schedule = ap.Schedule
+	a.Limits = ap.Limits
+	for _, v := range []struct {
+		src *bool
+		dst *int
+	}{
+		{ap.IEEE80211N, &a.IEEE80211N},
+		{ap.IEEE80211AC, &a.IEEE80211AC},
+		{ap.UsePsk, &a.UsePsk},
+		{ap.IsolateClients, &a.IsolateClients},
+		{ap.MACFilter, &a.MACFilter},
+		{ap.NoVirt, &a.NoVirt},
+		{ap.NoDNS, &a.NoDNS},
+		{ap.ETCHosts, &a.ETCHosts},
+		{ap.NoHaveGED, &a.NoHaveGED},
+	} {
+		if v.src != nil {
+			*v.dst = boolInt(*v.src)
+		}
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func intBool(i int) *bool {
+	b := i != 0
+	return &b
+}
+
+func strPtr(s string) *string {
+	return &s
 }
 
 func DefaultAccesPoint() *AccessPoint {
@@ -161,11 +326,39 @@ func DefaultAccesPoint() *AccessPoint {
 
 func (a *AccessPoint) State() *AccessPointConfig {
 	ap := &AccessPointConfig{
-		SSID:           a.SSID,
-		Passphrase:     a.Passphrase,
-		Gateway:        a.Gateway,
-		Interface:      a.WifiIface,
-		ShareInterfaec: a.InternetIface,
+		SSID:            a.SSID,
+		Passphrase:      a.Passphrase,
+		Gateway:         a.Gateway,
+		Interface:       a.WifiIface,
+		ShareInterfaec:  a.InternetIface,
+		Backend:         a.Backend,
+		Firewall:        a.Firewall,
+		ShareMethod:     a.ShareMethod,
+		Country:         a.Country,
+		FreqBand:        a.FreqBand,
+		IEEE80211N:      intBool(a.IEEE80211N),
+		IEEE80211AC:     intBool(a.IEEE80211AC),
+		HTCapAb:         strPtr(a.HTCapAb),
+		VHTCapAb:        strPtr(a.VHTCapAb),
+		WPAVersion:      a.WPAVersion,
+		UsePsk:          intBool(a.UsePsk),
+		IsolateClients:  intBool(a.IsolateClients),
+		MACFilter:       intBool(a.MACFilter),
+		MACFilterAccept: a.MACFilterAccept,
+		Driver:          a.Driver,
+		NoVirt:          intBool(a.NoVirt),
+		NewMACAddr:      strPtr(a.NewMACAddr),
+		DHCPDNS:         a.DHCPDNS,
+		NoDNS:           intBool(a.NoDNS),
+		ETCHosts:        intBool(a.ETCHosts),
+		NoHaveGED:       intBool(a.NoHaveGED),
+		AllowedMACs:     a.AllowedMACs,
+
+		Concurrent:       a.Concurrent,
+		VirtualInterface: a.VirtIface,
+		Portal:           a.Portal,
+		Schedule:         a.Schedule,
+		Limits:           a.Limits,
 	}
 	if a.Hidden == 1 {
 		ap.Hidden = true
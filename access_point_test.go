@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"reflect"
 	"testing"
 
 	"github.com/kr/pretty"
@@ -40,3 +41,85 @@ func TestLoadAPFromSrc(t *testing.T) {
 
 	pretty.Println(a)
 }
+
+func TestAccessPoint_State(t *testing.T) {
+	a := DefaultAccesPoint()
+	a.Country = "KE"
+	a.FreqBand = 5
+	a.IEEE80211N = 1
+	a.IEEE80211AC = 1
+	a.HTCapAb = "[HT40-][SHORT-GI-40]"
+	a.VHTCapAb = ""
+	a.WPAVersion = 1
+	a.IsolateClients = 0
+	a.MACFilter = 1
+	a.Driver = "rtl871xdrv"
+	a.NewMACAddr = "02:00:00:00:00:01"
+	a.Channel = "36"
+	a.Backend = BackendHostapd
+	b, err := json.Marshal(a.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := DefaultAccesPoint()
+	ap.HTCapAb = ""
+	c := &AccessPointConfig{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap.Update(c)
+	if !reflect.DeepEqual(a, ap) {
+		t.Errorf("expected %# v got %# v", pretty.Formatter(a), pretty.Formatter(ap))
+	}
+}
+
+func TestAccessPoint_AllowMAC(t *testing.T) {
+	a := DefaultAccesPoint()
+	err := a.AllowMAC("AA:BB:CC:DD:EE:FF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.AllowMAC("aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.AllowMAC("11:22:33:44:55:66")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.MACFilter != 1 {
+		t.Error("expected mac filter to be on")
+	}
+	if len(a.AllowedMACs) != 2 {
+		t.Fatalf("expected 2 macs got %v", a.AllowedMACs)
+	}
+	err = a.AllowMAC("not a mac")
+	if err == nil {
+		t.Error("expected an error")
+	}
+	err = a.DenyMAC("aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.DenyMAC("aa:bb:cc:dd:ee:ff")
+	if err == nil {
+		t.Error("expected an error")
+	}
+	// the filter does not open when the last station is removed.
+	err = a.DenyMAC("11:22:33:44:55:66")
+	if err == nil {
+		t.Error("expected an error for the last allowed station")
+	}
+	if a.MACFilter != 1 || len(a.AllowedMACs) != 1 {
+		t.Errorf("expected the filter to stay on got %d %v", a.MACFilter, a.AllowedMACs)
+	}
+	a.MACFilter = 0
+	err = a.DenyMAC("11:22:33:44:55:66")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.AllowedMACs) != 0 {
+		t.Errorf("expected no macs got %v", a.AllowedMACs)
+	}
+}
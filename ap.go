@@ -28,6 +28,24 @@ func ApCMD(ctx *cli.Context) error {
 	if ctx.IsSet(removeFlag) {
 		return RemoveApCMD(ctx)
 	}
+	if ctx.IsSet(allowFlag) || ctx.IsSet(denyFlag) {
+		return ApMACFilterCMD(ctx)
+	}
+	if ctx.IsSet(listMACsFlag) {
+		return ListApMACsCMD(ctx)
+	}
+	if ctx.IsSet(clientsFlag) {
+		return ApClientsCMD(ctx)
+	}
+	if ctx.IsSet(importFlag) {
+		return ImportApCMD(ctx)
+	}
+	if ctx.IsSet(applyScheduleFlag) {
+		return ApplyScheduleCMD(ctx)
+	}
+	if ctx.IsSet(limitsFlag) {
+		return ListApLimitsCMD(ctx)
+	}
 	if ctx.IsSet(configFlag) {
 		return ConfigApCMD(ctx)
 	}
@@ -59,30 +77,47 @@ func ConfigApCMD(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	err = checkDir(base)
-	if err != nil {
-		return err
-	}
 	ap := DefaultAccesPoint()
 	ap.Update(e)
-	if strings.Contains(name, "%s") {
-		name = fmt.Sprintf(name, ap.WifiIface)
+	if !ap.Concurrent {
+		_, err = wifiClientState(ap.WifiIface)
+		if err == nil {
+			fmt.Printf("wifi client is configured on %s, the access point will run next to it\n",
+				ap.WifiIface)
+			ap.Concurrent = true
+		}
 	}
-	filename := filepath.Join(base, name)
-	var buf bytes.Buffer
-	_, err = ap.WriteTo(&buf)
-	if err != nil {
-		return err
+	if ap.Concurrent {
+		err = prepareConcurrentAP(ap)
+		if err != nil {
+			return err
+		}
 	}
-	err = ioutil.WriteFile(filename, buf.Bytes(), 0644)
+	err = ap.Validate()
 	if err != nil {
+		if v, ok := err.(*ValidationError); ok {
+			fmt.Println(string(v.JSON()))
+		}
 		return err
 	}
-	fmt.Printf("successful written access point configuration to %s \n", filename)
-	state := &AccessPointState{Configg: ap.State()}
+	state := &AccessPointState{}
+	scheduled := false
 	as, err := accessPointState(ap.WifiIface)
 	if err == nil {
 		state.Enabled = as.Enabled
+		scheduled = len(as.Configg.Schedule) > 0
+	}
+	err = writeAccessPoint(ap, base, name)
+	if err != nil {
+		return err
+	}
+	state.Configg = ap.State()
+	if state.Enabled && scheduled && len(ap.Schedule) == 0 {
+		// the schedule left the services disabled, and maybe stopped.
+		err = enableApServices(state.Configg)
+		if err != nil {
+			return err
+		}
 	}
 	data, err := json.Marshal(state)
 	if err != nil {
@@ -93,6 +128,54 @@ func ConfigApCMD(ctx *cli.Context) error {
 		fmt.Sprintf(defaultAccessPointConfig, ap.WifiIface), data)
 }
 
+// writeAccessPoint writes the configuration files of ap for its backend. base
+// and name are the directory and name of create_ap configuration file.
+func writeAccessPoint(ap *AccessPoint, base, name string) error {
+	if ap.MACFilter == 1 {
+		err := writeMACAccept(ap)
+		if err != nil {
+			return err
+		}
+	}
+	err := writePortal(ap)
+	if err != nil {
+		return err
+	}
+	err = writeSchedule(ap)
+	if err != nil {
+		return err
+	}
+	err = writeLimits(ap)
+	if err != nil {
+		return err
+	}
+	switch ap.Backend {
+	case "", BackendCreateAP:
+		err = checkDir(base)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(name, "%s") {
+			name = fmt.Sprintf(name, ap.WifiIface)
+		}
+		filename := filepath.Join(base, name)
+		var buf bytes.Buffer
+		_, err = ap.WriteTo(&buf)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filename, buf.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("successful written access point configuration to %s \n", filename)
+		return nil
+	case BackendHostapd:
+		return writeHostapdBackend(ap)
+	}
+	return fmt.Errorf("fconf: unknown access point backend %s", ap.Backend)
+}
+
 func accessPointState(i string) (*AccessPointState, error) {
 	dir := os.Getenv("FCONF_CONFIGDIR")
 	if dir == "" {
@@ -114,6 +197,21 @@ func accessPointState(i string) (*AccessPointState, error) {
 	return a, nil
 }
 
+// enableApServices starts the access point services and enables them on boot.
+func enableApServices(c *AccessPointConfig) error {
+	for _, service := range apServices(c) {
+		err := startService(service)
+		if err != nil {
+			return err
+		}
+		err = enableService(service)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func EnableApCMD(ctx *cli.Context) error {
 	if ctx.IsSet(configFlag) {
 		err := ConfigApCMD(ctx)
@@ -129,14 +227,16 @@ func EnableApCMD(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	service := "create_ap@" + i
-	err = startService(service)
-	if err != nil {
-		return err
-	}
-	err = enableService(service)
-	if err != nil {
-		return err
+	if len(state.Configg.Schedule) > 0 {
+		err = enableApSchedule(state.Configg)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = enableApServices(state.Configg)
+		if err != nil {
+			return err
+		}
 	}
 	state.Enabled = true
 	data, err := json.Marshal(state)
@@ -159,14 +259,27 @@ func DisableApCMD(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	service := "create_ap@" + i
-	err = stopService(service)
-	if err != nil {
-		return err
+	if len(state.Configg.Schedule) > 0 {
+		timer := fmt.Sprintf(apScheduleTimer, i)
+		err = stopService(timer)
+		if err != nil {
+			return err
+		}
+		err = disableService(timer)
+		if err != nil {
+			return err
+		}
 	}
-	err = disableService(service)
-	if err != nil {
-		return err
+	services := apServices(state.Configg)
+	for k := len(services) - 1; k >= 0; k-- {
+		err = stopService(services[k])
+		if err != nil {
+			return err
+		}
+		err = disableService(services[k])
+		if err != nil {
+			return err
+		}
 	}
 	state.Enabled = false
 	data, err := json.Marshal(state)
@@ -193,6 +306,41 @@ func RemoveApCMD(ctx *cli.Context) error {
 		}
 	}
 
+	if a.Configg.Backend == BackendHostapd {
+		for _, f := range hostapdFiles(i) {
+			err = removeFile(f)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if a.Configg.Portal != nil {
+		err = removeFile(filepath.Join(systemdBase, fmt.Sprintf(portalService, i)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if a.Configg.Limits != nil {
+		for _, f := range []string{
+			filepath.Join(systemdBase, fmt.Sprintf(apLimitsService, i)),
+			fmt.Sprintf(apLimitsBatch, i),
+		} {
+			err = removeFile(f)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	if len(a.Configg.Schedule) > 0 {
+		for _, f := range []string{apScheduleTimer, apScheduleService} {
+			err = removeFile(filepath.Join(systemdBase, fmt.Sprintf(f, i)))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
 	// remove the state file
 	stateFile := filepath.Join(stateDir(),
 		fmt.Sprintf(defaultAccessPointConfig, i))
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+//APClient is a station connected to the access point.
+type APClient struct {
+	MAC           string `json:"mac"`
+	IP            string `json:"ip,omitempty"`
+	Hostname      string `json:"hostname,omitempty"`
+	Signal        int    `json:"signal"`
+	ConnectedTime int    `json:"connected_time"`
+	TxBytes       int64  `json:"tx_bytes"`
+	RxBytes       int64  `json:"rx_bytes"`
+}
+
+//DHCPLease is an entry of dnsmasq lease file.
+type DHCPLease struct {
+	Expiry   int64
+	MAC      string
+	IP       string
+	Hostname string
+}
+
+//ParseLeases parses dnsmasq lease file. Each line looks like
+//
+//	1500000000 aa:bb:cc:dd:ee:ff 192.168.12.45 android-phone 01:aa:bb:cc:dd:ee:ff
+func ParseLeases(r io.Reader) ([]*DHCPLease, error) {
+	var leases []*DHCPLease
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 4 {
+			continue
+		}
+		// lines of DHCPv6 leases look different
+		if f[0] == "duid" {
+			continue
+		}
+		e, err := strconv.ParseInt(f[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fconf: bad lease %s", s.Text())
+		}
+		l := &DHCPLease{
+			Expiry: e,
+			MAC:    strings.ToLower(f[1]),
+			IP:     f[2],
+		}
+		if f[3] != "*" {
+			l.Hostname = f[3]
+		}
+		leases = append(leases, l)
+	}
+	return leases, s.Err()
+}
+
+// parseStation parses the reply to hostapd STA-FIRST and STA-NEXT commands.
+// The first line is the mac address of the station, the rest are key=value
+// pairs.
+func parseStation(src string) *APClient {
+	lines := strings.SplitN(strings.TrimSpace(src), "\n", 2)
+	if len(lines) == 0 || strings.Contains(lines[0], "=") || lines[0] == "" ||
+		strings.HasPrefix(lines[0], "FAIL") {
+		return nil
+	}
+	c := &APClient{MAC: strings.ToLower(lines[0])}
+	if len(lines) == 1 {
+		return c
+	}
+	kv := parseWPAKeyValue(lines[1])
+	c.Signal, _ = strconv.Atoi(kv["signal"])
+	c.ConnectedTime, _ = strconv.Atoi(kv["connected_time"])
+	c.TxBytes, _ = strconv.ParseInt(kv["tx_bytes"], 10, 64)
+	c.RxBytes, _ = strconv.ParseInt(kv["rx_bytes"], 10, 64)
+	return c
+}
+
+//Stations returns the stations associated with hostapd. This works with
+//hostapd control interface, which speaks the same protocol as
+//wpa_supplicant.
+func (w *WPACtrl) Stations() ([]*APClient, error) {
+	var result []*APClient
+	r, err := w.Request("STA-FIRST")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		c := parseStation(r)
+		if c == nil {
+			break
+		}
+		result = append(result, c)
+		r, err = w.Request("STA-NEXT " + c.MAC)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// apRuntimeFiles returns the hostapd control directory and the dnsmasq lease
+// file of the access point. create_ap keeps them in the directory it creates
+// with mktemp on startup.
+func apRuntimeFiles(c *AccessPointConfig) (ctrl string, leases string, err error) {
+	if c.Backend == BackendHostapd {
+		return hostapdCtrl, fmt.Sprintf(dnsmasqLeases, c.Interface), nil
+	}
+	m, err := filepath.Glob("/tmp/create_ap." + c.Interface + ".conf.*")
+	if err != nil {
+		return "", "", err
+	}
+	if len(m) == 0 {
+		return "", "", fmt.Errorf("fconf: create_ap is not running on %s", c.Interface)
+	}
+	return filepath.Join(m[0], "hostapd_ctrl"), filepath.Join(m[0], "dnsmasq.leases"), nil
+}
+
+// apClientIface returns the interface hostapd serves the access point on. The
+// hostapd backend saves the virtual interface it creates, create_ap names its
+// own and keeps only that control socket in ctrlDir.
+func apClientIface(c *AccessPointConfig, ctrlDir string) string {
+	if c.VirtualInterface != "" {
+		return c.VirtualInterface
+	}
+	if c.Concurrent && c.Backend != BackendHostapd {
+		m, err := filepath.Glob(filepath.Join(ctrlDir, "*"))
+		if err == nil && len(m) == 1 {
+			return filepath.Base(m[0])
+		}
+	}
+	return c.Interface
+}
+
+// apClients returns the stations connected to the access point on interface
+// i with the address and hostname from their DHCP lease.
+func apClients(ctrlDir, leaseFile, i string) ([]*APClient, error) {
+	c, err := DialWPACtrl(ctrlDir, i)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	clients, err := c.Stations()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(leaseFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return clients, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	leases, err := ParseLeases(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range clients {
+		var expiry int64
+		for _, l := range leases {
+			if l.MAC == v.MAC && l.Expiry >= expiry {
+				expiry = l.Expiry
+				v.IP = l.IP
+				v.Hostname = l.Hostname
+			}
+		}
+	}
+	return clients, nil
+}
+
+//ApClientsCMD prints a json array of the stations connected to the access
+//point.
+func ApClientsCMD(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	state, err := accessPointState(i)
+	if err != nil {
+		return err
+	}
+	ctrl, leases, err := apRuntimeFiles(state.Configg)
+	if err != nil {
+		return err
+	}
+	clients, err := apClients(ctrl, leases, apClientIface(state.Configg, ctrl))
+	if err != nil {
+		return err
+	}
+	if clients == nil {
+		clients = []*APClient{}
+	}
+	b, err := json.Marshal(clients)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
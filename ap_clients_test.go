@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLeases(t *testing.T) {
+	f, err := os.Open("fixture/dnsmasq.leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	l, err := ParseLeases(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := []*DHCPLease{
+		{1508400000, "aa:bb:cc:dd:ee:01", "192.168.12.45", "android-phone"},
+		{1508300000, "aa:bb:cc:dd:ee:02", "192.168.12.60", ""},
+		{1508200000, "aa:bb:cc:dd:ee:02", "192.168.12.61", "old-laptop"},
+		{1508400000, "aa:bb:cc:dd:ee:03", "192.168.12.77", "gone"},
+	}
+	if !reflect.DeepEqual(l, e) {
+		t.Errorf("expected %v got %v", e, l)
+	}
+}
+
+func TestApClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sta := map[string]string{
+		"STA-FIRST": "aa:bb:cc:dd:ee:01\nflags=[AUTH][ASSOC][AUTHORIZED]\n" +
+			"rx_packets=120\ntx_packets=80\nrx_bytes=10240\ntx_bytes=20480\n" +
+			"signal=-48\nconnected_time=360\n",
+		"STA-NEXT aa:bb:cc:dd:ee:01": "aa:bb:cc:dd:ee:02\nflags=[AUTH][ASSOC][AUTHORIZED]\n" +
+			"rx_bytes=5\ntx_bytes=7\nsignal=-70\nconnected_time=12\n",
+		"STA-NEXT aa:bb:cc:dd:ee:02": "aa:bb:cc:dd:ee:04\nsignal=-80\n",
+		"STA-NEXT aa:bb:cc:dd:ee:04": "",
+	}
+	stop := fakeWPASupplicant(t, dir, "wlan0", func(cmd string) []string {
+		return []string{sta[cmd]}
+	})
+	defer stop()
+	c, err := apClients(dir, "fixture/dnsmasq.leases", "wlan0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := []*APClient{
+		{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.12.45", Hostname: "android-phone",
+			Signal: -48, ConnectedTime: 360, TxBytes: 20480, RxBytes: 10240},
+		{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.12.60",
+			Signal: -70, ConnectedTime: 12, TxBytes: 7, RxBytes: 5},
+		{MAC: "aa:bb:cc:dd:ee:04", Signal: -80},
+	}
+	if !reflect.DeepEqual(c, e) {
+		t.Errorf("expected %v got %v", e, c)
+	}
+}
+
+func TestApClientIface(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "wlan0ap"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := []struct {
+		c *AccessPointConfig
+		e string
+	}{
+		{&AccessPointConfig{Interface: "wlan0"}, "wlan0"},
+		{&AccessPointConfig{Interface: "wlan0", Concurrent: true,
+			Backend: BackendHostapd, VirtualInterface: "wlan0_ap"}, "wlan0_ap"},
+		{&AccessPointConfig{Interface: "wlan0", Concurrent: true}, "wlan0ap"},
+	}
+	for _, v := range sample {
+		if i := apClientIface(v.c, dir); i != v.e {
+			t.Errorf("expected %s got %s", v.e, i)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+	ini "gopkg.in/ini.v1"
+)
+
+//ImportAPConf loads a create_ap configuration file written by hand or by an
+//older setup. Settings missing from src keep the values of
+//DefaultAccesPoint.
+func ImportAPConf(src []byte) (*AccessPoint, error) {
+	cfg, err := ini.Load(src)
+	if err != nil {
+		return nil, err
+	}
+	sec := cfg.Section("")
+	// create_ap accepts 1+2 for WPA and WPA2, which is wpa=3 in hostapd.
+	if k := sec.Key("WPA_VERSION"); k.String() == "1+2" || k.String() == "2+1" {
+		k.SetValue("3")
+	}
+	if k := sec.Key("CHANNEL"); k.String() != "" && k.String() != "default" {
+		if _, err := strconv.Atoi(k.String()); err != nil {
+			return nil, fmt.Errorf("fconf: bad channel %s", k.String())
+		}
+	}
+	a := DefaultAccesPoint()
+	err = cfg.MapTo(a)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// readMACAccept reads the stations listed in a hostapd accept file. Comments
+// and blank lines are skipped.
+func readMACAccept(r io.Reader) ([]string, error) {
+	var macs []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		// the accept file can have a vlan id after the address.
+		m, err := net.ParseMAC(strings.Fields(l)[0])
+		if err != nil {
+			return nil, err
+		}
+		macs = append(macs, m.String())
+	}
+	return macs, s.Err()
+}
+
+//ImportApCMD takes over an access point configured with a create_ap
+//configuration file. The settings are saved in fconf state and the file is
+//written back where the create_ap service reads it.
+func ImportApCMD(ctx *cli.Context) error {
+	src := ctx.String(importFlag)
+	if src == "" {
+		return errors.New("fconf: missing argument")
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	ap, err := ImportAPConf(b)
+	if err != nil {
+		return err
+	}
+	if ap.Daemonize != 0 {
+		fmt.Println("WARN: DAEMONIZE is turned off, the access point is run by systemd")
+		ap.Daemonize = 0
+	}
+	ap.Backend = BackendCreateAP
+	if ap.MACFilterAccept != "" {
+		f, err := os.Open(ap.MACFilterAccept)
+		if err == nil {
+			ap.AllowedMACs, err = readMACAccept(f)
+			_ = f.Close()
+			if err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err = ap.Validate()
+	if err != nil {
+		if v, ok := err.(*ValidationError); ok {
+			fmt.Println(string(v.JSON()))
+		}
+		return err
+	}
+	err = writeAccessPoint(ap, ctx.String("dir"), ctx.String("name"))
+	if err != nil {
+		return err
+	}
+	state := &AccessPointState{Configg: ap.State()}
+	as, err := accessPointState(ap.WifiIface)
+	if err == nil {
+		state.Enabled = as.Enabled
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported access point on %s from %s \n", ap.WifiIface, src)
+	return keepState(
+		fmt.Sprintf(defaultAccessPointConfig, ap.WifiIface), data)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kr/pretty"
+)
+
+func TestImportAPConf(t *testing.T) {
+	for _, name := range []string{
+		"fixture/create_ap.conf",
+		"fixture/create_ap_import.conf",
+	} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := ImportAPConf(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the state has no daemonize setting, it is always off.
+		a.Daemonize = 0
+		b, err = json.Marshal(a.State())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &AccessPointConfig{}
+		err = json.Unmarshal(b, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ap := DefaultAccesPoint()
+		ap.Update(c)
+		if !reflect.DeepEqual(a, ap) {
+			t.Errorf("%s: expected %# v got %# v", name, pretty.Formatter(a), pretty.Formatter(ap))
+		}
+	}
+	a, err := ImportAPConf([]byte("WPA_VERSION=1+2\nSSID=x\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.WPAVersion != 3 {
+		t.Errorf("expected wpa version 3 got %d", a.WPAVersion)
+	}
+	if a.Gateway != DefaultAccesPoint().Gateway {
+		t.Errorf("expected default gateway got %s", a.Gateway)
+	}
+	_, err = ImportAPConf([]byte("CHANNEL=auto\n"))
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestReadMACAccept(t *testing.T) {
+	f, err := os.Open("fixture/hostapd.accept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	macs, err := readMACAccept(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := []string{"00:11:22:33:44:55", "aa:bb:cc:dd:ee:ff"}
+	if !reflect.DeepEqual(macs, e) {
+		t.Errorf("expected %v got %v", e, macs)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//FieldError is a validation error for a single configuration field. Field is
+//the json name of the field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//ValidationError is returned when a configuration has invalid fields.
+type ValidationError struct {
+	Errors []*FieldError `json:"errors"`
+}
+
+func (v *ValidationError) Error() string {
+	var s []string
+	for _, e := range v.Errors {
+		s = append(s, e.Field+": "+e.Message)
+	}
+	return "invalid configuration: " + strings.Join(s, ", ")
+}
+
+//JSON returns the json representation of v.
+func (v *ValidationError) JSON() []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func (v *ValidationError) add(field, format string, args ...interface{}) {
+	v.Errors = append(v.Errors, &FieldError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// interfaceExists is a variable so tests don't depend on the interfaces of the
+// host.
+var interfaceExists = func(name string) bool {
+	_, err := net.InterfaceByName(name)
+	return err == nil
+}
+
+// fileExists is a variable for the same reason as interfaceExists.
+var fileExists = func(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// Channels usable by an access point. 5GHz DFS channels are left out, since
+// neither create_ap nor the hostapd backend enable radar detection.
+var (
+	channels24     = channelRange(1, 13, 1)
+	channels24FCC  = channelRange(1, 11, 1)
+	channels5UNII1 = channelRange(36, 48, 4)
+	channels5      = append(channelRange(36, 48, 4), channelRange(149, 165, 4)...)
+
+	// countries that only allow channels 1 to 11 on 2.4GHz.
+	fccCountries = map[string]bool{"US": true, "CA": true, "TW": true, "PR": true}
+
+	// ETSI countries do not allow an access point on 5GHz UNII-3.
+	etsiCountries = map[string]bool{
+		"AT": true, "BE": true, "BG": true, "CH": true, "CY": true, "CZ": true,
+		"DE": true, "DK": true, "EE": true, "ES": true, "FI": true, "FR": true,
+		"GB": true, "GR": true, "HR": true, "HU": true, "IE": true, "IS": true,
+		"IT": true, "LI": true, "LT": true, "LU": true, "LV": true, "MT": true,
+		"NL": true, "NO": true, "PL": true, "PT": true, "RO": true, "SE": true,
+		"SI": true, "SK": true,
+	}
+)
+
+func channelRange(from, to, step int) []int {
+	var c []int
+	for i := from; i <= to; i += step {
+		c = append(c, i)
+	}
+	return c
+}
+
+// allowedChannels returns the channels an access point can use on band in
+// country.
+func allowedChannels(band float64, country string) []int {
+	if band == 5 {
+		if etsiCountries[country] {
+			return channels5UNII1
+		}
+		return channels5
+	}
+	if fccCountries[country] {
+		return channels24FCC
+	}
+	return channels24
+}
+
+// isCountryCode returns true if c is two upper case ASCII letters.
+func isCountryCode(c string) bool {
+	return len(c) == 2 && strings.IndexFunc(c, func(r rune) bool {
+		return r < 'A' || r > 'Z'
+	}) == -1
+}
+
+//Validate checks the access point settings, it returns a *ValidationError
+//listing all invalid fields.
+func (a *AccessPoint) Validate() error {
+	v := &ValidationError{}
+	if len(a.SSID) < 1 || len(a.SSID) > 32 {
+		v.add("ssid", "must be 1 to 32 bytes long")
+	} else if strings.IndexFunc(a.SSID, unicode.IsControl) != -1 {
+		// hostapd.conf and the create_ap arguments are line based.
+		v.add("ssid", "can not contain control characters")
+	}
+	if a.Passphrase != "" {
+		if len(a.Passphrase) == 64 {
+			if !isHex(a.Passphrase) {
+				v.add("passphrase", "a 64 character passphrase must be a hex PSK")
+			}
+		} else if len(a.Passphrase) < 8 || len(a.Passphrase) > 63 {
+			v.add("passphrase", "must be 8 to 63 characters or a 64 hex digits PSK")
+		} else {
+			for _, c := range a.Passphrase {
+				if c < 32 || c > 126 {
+					v.add("passphrase", "must be printable ASCII")
+					break
+				}
+			}
+		}
+	}
+	if a.Country != "" && !isCountryCode(a.Country) {
+		v.add("country", "must be a two letter ISO 3166-1 code")
+	}
+	bandOK := true
+	switch a.FreqBand {
+	case 2.4:
+	case 5:
+		if a.Country == "" {
+			v.add("country", "is required for 5GHz")
+			bandOK = false
+		}
+	default:
+		v.add("freq_band", "must be 2.4 or 5")
+		bandOK = false
+	}
+	if a.Channel != "" && a.Channel != "default" {
+		c, err := strconv.Atoi(a.Channel)
+		if err != nil {
+			v.add("channel", "must be a number")
+		} else if bandOK && !a.clientChannel {
+			ok := false
+			for _, i := range allowedChannels(a.FreqBand, a.Country) {
+				if i == c {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				country := a.Country
+				if country == "" {
+					country = "any country"
+				}
+				v.add("channel", "%d is not allowed on %vGHz in %s", c, a.FreqBand, country)
+			}
+		}
+	}
+	validateGateway(v, a.Gateway)
+	if a.InternetIface != "" {
+		if a.InternetIface == a.apIface() ||
+			(a.InternetIface == a.WifiIface && !a.Concurrent) {
+			v.add("shared_interface", "can not be the access point interface")
+		} else if !interfaceExists(a.InternetIface) {
+			v.add("shared_interface", "interface %s does not exist", a.InternetIface)
+		}
+	}
+	if p := a.Portal; p != nil {
+		if p.Port < 0 || p.Port > 65535 {
+			v.add("portal.port", "must be a port number")
+		}
+		if p.DNSPort < 0 || p.DNSPort > 65535 {
+			v.add("portal.dns_port", "must be a port number")
+		}
+		if p.port() == p.dnsPort() {
+			v.add("portal.dns_port", "can not be the same as port")
+		}
+		if p.Page != "" && !fileExists(p.Page) {
+			v.add("portal.page", "file %s does not exist", p.Page)
+		}
+		if a.Firewall == FirewallNftables {
+			// the portal rules are iptables only.
+			v.add("portal", "needs the iptables firewall")
+		}
+	}
+	if a.Limits != nil {
+		validateLimits(v, a.Limits, a.Gateway)
+	}
+	for k, w := range a.Schedule {
+		_, _, _, err := w.parse()
+		if err != nil {
+			v.add(fmt.Sprintf("schedule.%d", k), "%v", err)
+		}
+	}
+	if len(v.Errors) > 0 {
+		return v
+	}
+	return nil
+}
+
+// validateGateway checks that gw is a private IPv4 address that can be the
+// gateway of the /24 network used for access point clients.
+func validateGateway(v *ValidationError, gw string) {
+	ip := net.ParseIP(gw).To4()
+	if ip == nil {
+		v.add("gateway", "must be an IPv4 address")
+		return
+	}
+	private := ip[0] == 10 ||
+		(ip[0] == 172 && ip[1]&0xf0 == 16) ||
+		(ip[0] == 192 && ip[1] == 168)
+	if !private {
+		v.add("gateway", "must be a private address")
+		return
+	}
+	if ip[3] == 0 || ip[3] == 255 {
+		v.add("gateway", "can not be the network or broadcast address")
+	}
+}
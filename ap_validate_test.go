@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAccessPoint_Validate(t *testing.T) {
+	exists := interfaceExists
+	defer func() {
+		interfaceExists = exists
+	}()
+	interfaceExists = func(name string) bool {
+		return name == "eth0" || name == "wlan0"
+	}
+
+	a := DefaultAccesPoint()
+	err := a.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := []struct {
+		update func(*AccessPoint)
+		fields []string
+	}{
+		{func(a *AccessPoint) { a.SSID = "" }, []string{"ssid"}},
+		{func(a *AccessPoint) { a.SSID = "a very long ssid that is more than 32 bytes" }, []string{"ssid"}},
+		{func(a *AccessPoint) { a.SSID = "fconf\nwpa=0" }, []string{"ssid"}},
+		{func(a *AccessPoint) { a.Passphrase = "short" }, []string{"passphrase"}},
+		{func(a *AccessPoint) {
+			a.Passphrase = "f42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e"
+		}, nil},
+		{func(a *AccessPoint) {
+			a.Passphrase = "z42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e"
+		}, []string{"passphrase"}},
+		{func(a *AccessPoint) { a.Channel = "13" }, nil},
+		{func(a *AccessPoint) { a.Channel = "13"; a.Country = "US" }, []string{"channel"}},
+		{func(a *AccessPoint) { a.Channel = "13"; a.Country = "US"; a.Concurrent = true }, []string{"channel"}},
+		{func(a *AccessPoint) { a.Channel = "13"; a.Country = "US"; a.clientChannel = true }, nil},
+		{func(a *AccessPoint) { a.Channel = "36"; a.FreqBand = 5 }, []string{"country"}},
+		{func(a *AccessPoint) { a.Channel = "149"; a.FreqBand = 5; a.Country = "KE" }, nil},
+		{func(a *AccessPoint) { a.Channel = "149"; a.FreqBand = 5; a.Country = "DE" }, []string{"channel"}},
+		{func(a *AccessPoint) { a.Channel = "52"; a.FreqBand = 5; a.Country = "US" }, []string{"channel"}},
+		{func(a *AccessPoint) { a.Country = "kenya" }, []string{"country"}},
+		{func(a *AccessPoint) { a.FreqBand = 6 }, []string{"freq_band"}},
+		{func(a *AccessPoint) { a.Gateway = "8.8.8.1" }, []string{"gateway"}},
+		{func(a *AccessPoint) { a.Gateway = "10.0.0.255" }, []string{"gateway"}},
+		{func(a *AccessPoint) { a.Gateway = "172.20.1.1" }, nil},
+		{func(a *AccessPoint) { a.Gateway = "fe80::1" }, []string{"gateway"}},
+		{func(a *AccessPoint) { a.InternetIface = "wlan0" }, []string{"shared_interface"}},
+		{func(a *AccessPoint) { a.InternetIface = "ppp0" }, []string{"shared_interface"}},
+		{func(a *AccessPoint) { a.SSID = ""; a.Gateway = "" }, []string{"ssid", "gateway"}},
+		{func(a *AccessPoint) { a.Portal = &CaptivePortal{} }, nil},
+		{func(a *AccessPoint) { a.Portal = &CaptivePortal{Port: 2053} }, []string{"portal.dns_port"}},
+		{func(a *AccessPoint) { a.Portal = &CaptivePortal{}; a.Firewall = FirewallNftables }, []string{"portal"}},
+		{func(a *AccessPoint) { a.Portal = &CaptivePortal{Page: "fixture/missing.html"} }, []string{"portal.page"}},
+		{func(a *AccessPoint) {
+			a.Limits = &APLimits{
+				PerClient: BandwidthLimit{Download: 1024},
+				Clients:   map[string]*BandwidthLimit{"192.168.12.9": {Upload: 64}},
+			}
+		}, nil},
+		{func(a *AccessPoint) {
+			a.Limits = &APLimits{
+				Total:   BandwidthLimit{Upload: -1},
+				Clients: map[string]*BandwidthLimit{"192.168.12.1": {Upload: 64}, "10.0.0.2": {}},
+			}
+		}, []string{"limits.total.upload", "limits.clients.10.0.0.2", "limits.clients.192.168.12.1"}},
+		{func(a *AccessPoint) {
+			a.Schedule = []*APWindow{{Start: "06:00", Stop: "22:00"}, {Start: "25:00", Stop: "22:00"}}
+		}, []string{"schedule.1"}},
+	}
+	for k, v := range sample {
+		a := DefaultAccesPoint()
+		v.update(a)
+		err := a.Validate()
+		if v.fields == nil {
+			if err != nil {
+				t.Errorf("%d: unexpected error %v", k, err)
+			}
+			continue
+		}
+		e, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%d: expected validation error got %v", k, err)
+			continue
+		}
+		if len(e.Errors) != len(v.fields) {
+			t.Errorf("%d: expected %v got %s", k, v.fields, e.JSON())
+			continue
+		}
+		for i, f := range v.fields {
+			if e.Errors[i].Field != f {
+				t.Errorf("%d: expected %s got %s", k, f, e.Errors[i].Field)
+			}
+		}
+	}
+}
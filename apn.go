@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// apnOverrides is the file in the state directory with our own APN
+	// settings. It has the same layout as apnDB, an entry replaces the
+	// profiles of the operator.
+	apnOverrides = "apns.json"
+
+	defaultDial = "*99#"
+)
+
+//APNProfile are the data settings of an operator.
+type APNProfile struct {
+	Name     string `json:"name"`
+	APN      string `json:"apn"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Dial     string `json:"dial,omitempty"`
+}
+
+// loadAPNOverrides reads the overrides file, it is fine if there is none.
+func loadAPNOverrides() (map[string][]*APNProfile, error) {
+	b, err := ioutil.ReadFile(filepath.Join(stateDir(), apnOverrides))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	o := make(map[string][]*APNProfile)
+	err = json.Unmarshal(b, &o)
+	if err != nil {
+		return nil, fmt.Errorf("fconf: bad %s %v", apnOverrides, err)
+	}
+	return o, nil
+}
+
+//LookupAPN returns the operator profiles for the SIM with the given IMSI. The
+//first five or six digits of the IMSI are the MCC and MNC, the longer match
+//wins.
+func LookupAPN(imsi string) ([]*APNProfile, error) {
+	if len(imsi) < 6 || strings.IndexFunc(imsi, func(r rune) bool {
+		return r < '0' || r > '9'
+	}) != -1 {
+		return nil, fmt.Errorf("fconf: bad imsi %q", imsi)
+	}
+	o, err := loadAPNOverrides()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range []string{imsi[:6], imsi[:5]} {
+		if p, ok := o[k]; ok {
+			return p, nil
+		}
+		if p, ok := apnDB[k]; ok {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("fconf: no APN settings for the operator of %s, set apn or add the operator to %s",
+		imsi, filepath.Join(stateDir(), apnOverrides))
+}
+
+// chooseAPN returns the profile named name, or the only profile when name is
+// empty.
+func chooseAPN(profiles []*APNProfile, name string) (*APNProfile, error) {
+	if name == "" {
+		if len(profiles) == 1 {
+			return profiles[0], nil
+		}
+		var names []string
+		for _, p := range profiles {
+			names = append(names, p.Name)
+		}
+		return nil, fmt.Errorf("fconf: the operator has several APN profiles, set apn_profile to one of %s",
+			strings.Join(names, ", "))
+	}
+	for _, p := range profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("fconf: unknown APN profile %s", name)
+}
+
+//FillAPN fills in the APN settings of the 3g modem from its IMSI when the
+//APN is missing.
+func (c *ThreeG) FillAPN() error {
+	if c.APN == "" {
+		p, err := LookupAPN(c.IMSI)
+		if err != nil {
+			return err
+		}
+		a, err := chooseAPN(p, c.APNProfile)
+		if err != nil {
+			return err
+		}
+		c.APN = a.APN
+		if c.Username == "" {
+			c.Username = a.Username
+		}
+		if c.Password == "" {
+			c.Password = a.Password
+		}
+		if c.Dial == "" {
+			c.Dial = a.Dial
+		}
+	}
+	if c.Dial == "" {
+		c.Dial = defaultDial
+	}
+	return nil
+}
+
+//FillAPN fills in the APN settings of the 4g modem from its IMSI when the
+//APN is missing. Nothing is done without an IMSI, the modem may connect on its
+//own.
+func (f *FourG) FillAPN() error {
+	if f.APN != "" || f.IMSI == "" {
+		return nil
+	}
+	p, err := LookupAPN(f.IMSI)
+	if err != nil {
+		return err
+	}
+	a, err := chooseAPN(p, f.APNProfile)
+	if err != nil {
+		return err
+	}
+	f.APN = a.APN
+	if f.Username == "" {
+		f.Username = a.Username
+	}
+	if f.Password == "" {
+		f.Password = a.Password
+	}
+	return nil
+}
+
+// listAPNs prints the profiles of the operator of the SIM with the given
+// IMSI as json.
+func listAPNs(imsi string) error {
+	if imsi == "" {
+		return errors.New("fconf: missing imsi")
+	}
+	p, err := LookupAPN(imsi)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
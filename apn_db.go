@@ -0,0 +1,71 @@
+package main
+
+// apnDB are the data settings of the operators we deploy with, keyed by the
+// MCC and MNC of their SIMs. Operators missing here, or with settings that
+// changed, go in the overrides file.
+var apnDB = map[string][]*APNProfile{
+	// Kenya
+	"63902": {
+		{Name: "Safaricom", APN: "safaricom", Username: "saf", Password: "data"},
+		{Name: "Safaricom business", APN: "safaricomltd"},
+	},
+	"63903": {{Name: "Airtel", APN: "internet"}},
+	"63907": {{Name: "Telkom", APN: "internet"}},
+
+	// Tanzania
+	"64002": {{Name: "Tigo", APN: "tigoweb"}},
+	"64004": {{Name: "Vodacom", APN: "internet"}},
+	"64005": {{Name: "Airtel", APN: "internet"}},
+	"64009": {{Name: "Halotel", APN: "internet"}},
+
+	// Uganda
+	"64101": {{Name: "Airtel", APN: "internet"}},
+	"64110": {{Name: "MTN", APN: "internet"}},
+
+	// Ethiopia
+	"63601": {{Name: "Ethio Telecom", APN: "etc.com"}},
+
+	// Malawi
+	"65001": {{Name: "TNM", APN: "internet"}},
+	"65010": {{Name: "Airtel", APN: "internet"}},
+
+	// Zambia
+	"64501": {{Name: "Airtel", APN: "internet"}},
+	"64502": {{Name: "MTN", APN: "internet"}},
+
+	// Mozambique
+	"64301": {{Name: "mCel", APN: "internet"}},
+	"64304": {{Name: "Vodacom", APN: "internet"}},
+
+	// Ghana
+	"62001": {{Name: "MTN", APN: "internet"}},
+	"62002": {
+		{Name: "Vodafone", APN: "browse"},
+		{Name: "Vodafone postpaid", APN: "internet"},
+	},
+	"62003": {{Name: "AirtelTigo", APN: "internet"}},
+
+	// Nigeria
+	"62120": {{Name: "Airtel", APN: "internet.ng.airtel.com", Username: "internet", Password: "internet"}},
+	"62130": {{Name: "MTN", APN: "web.gprs.mtnnigeria.net", Username: "web", Password: "web"}},
+	"62150": {{Name: "Glo", APN: "gloflat", Username: "flat", Password: "flat"}},
+	"62160": {{Name: "9mobile", APN: "9mobile"}},
+
+	// Burkina Faso
+	"61302": {{Name: "Orange", APN: "internet"}},
+
+	// Mali
+	"61002": {{Name: "Orange", APN: "internet"}},
+
+	// Senegal
+	"60801": {{Name: "Orange", APN: "internet"}},
+
+	// Cameroon
+	"62401": {{Name: "MTN", APN: "INTERNET"}},
+	"62402": {{Name: "Orange", APN: "orangecmgprs", Username: "orange", Password: "orange"}},
+
+	// South Africa
+	"65501": {{Name: "Vodacom", APN: "internet"}},
+	"65507": {{Name: "Cell C", APN: "internet"}},
+	"65510": {{Name: "MTN", APN: "internet"}},
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupAPN(t *testing.T) {
+	dir := testStateDir(t)
+
+	c := &ThreeG{IMSI: "639030000000002"}
+	err := c.FillAPN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.APN != "internet" || c.Dial != defaultDial {
+		t.Errorf("unexpected settings %+v", c)
+	}
+
+	// Safaricom has two profiles.
+	c = &ThreeG{IMSI: "639020000000001"}
+	err = c.FillAPN()
+	if err == nil {
+		t.Error("expected an error")
+	}
+	c.APNProfile = "safaricom"
+	err = c.FillAPN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.APN != "safaricom" || c.Username != "saf" || c.Password != "data" {
+		t.Errorf("unexpected settings %+v", c)
+	}
+
+	// settings given by the user are kept.
+	c = &ThreeG{IMSI: "639030000000002", APN: "custom", Dial: "*99***1#"}
+	err = c.FillAPN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.APN != "custom" || c.Dial != "*99***1#" {
+		t.Errorf("unexpected settings %+v", c)
+	}
+
+	_, err = LookupAPN("999990000000000")
+	if err == nil {
+		t.Error("expected an error")
+	}
+	_, err = LookupAPN("63903")
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	// the overrides file replaces the settings of the operator, and adds
+	// operators with three digit MNC.
+	err = ioutil.WriteFile(filepath.Join(dir, apnOverrides), []byte(`{
+	"63903": [{"name": "Airtel", "apn": "airtelgprs.com"}],
+	"310260": [{"name": "T-Mobile", "apn": "fast.t-mobile.com"}]
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &FourG{IMSI: "639030000000002"}
+	err = f.FillAPN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.APN != "airtelgprs.com" {
+		t.Errorf("expected airtelgprs.com got %s", f.APN)
+	}
+	p, err := LookupAPN("310260000000003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1 || p[0].APN != "fast.t-mobile.com" {
+		t.Errorf("unexpected profiles %v", p)
+	}
+}
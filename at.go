@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// modemDevice is the AT command port of a modem, udev links it by the
+	// IMSI of the SIM.
+	modemDevice = "/dev/%s.imsi"
+
+	defaultATTimeout = 5 * time.Second
+)
+
+//ATError is returned when a modem replies ERROR to a command.
+type ATError struct {
+	Command string
+	Reply   string
+}
+
+func (e *ATError) Error() string {
+	return fmt.Sprintf("fconf: %s failed with %s", e.Command, e.Reply)
+}
+
+//ATPort sends AT commands to a modem.
+type ATPort struct {
+	rw      io.ReadWriter
+	r       *bufio.Reader
+	Timeout time.Duration
+}
+
+//NewATPort returns an ATPort talking to a modem over rw.
+func NewATPort(rw io.ReadWriter) *ATPort {
+	return &ATPort{rw: rw, r: bufio.NewReader(rw), Timeout: defaultATTimeout}
+}
+
+//OpenATPort opens the serial AT command port name.
+func OpenATPort(name string) (*ATPort, error) {
+	o, err := exec.Command("stty", "-F", name, "115200", "raw", "-echo").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("fconf: stty %s %v %s", name, err, o)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewATPort(f), nil
+}
+
+//Close closes the port if it can be closed.
+func (p *ATPort) Close() error {
+	if c, ok := p.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+//Command sends cmd and returns the lines of the reply without the echo of
+//the command and the final result code.
+func (p *ATPort) Command(cmd string) ([]string, error) {
+	if d, ok := p.rw.(interface {
+		SetReadDeadline(time.Time) error
+	}); ok && p.Timeout > 0 {
+		_ = d.SetReadDeadline(time.Now().Add(p.Timeout))
+	}
+	_, err := io.WriteString(p.rw, cmd+"\r")
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		l, err := p.r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("fconf: reading reply to %s %v", cmd, err)
+		}
+		l = strings.TrimSpace(l)
+		switch {
+		case l == "" || l == cmd:
+		case l == "OK":
+			return lines, nil
+		case l == "ERROR" || strings.HasPrefix(l, "+CME ERROR") ||
+			strings.HasPrefix(l, "+CMS ERROR"):
+			return nil, &ATError{Command: cmd, Reply: l}
+		default:
+			lines = append(lines, l)
+		}
+	}
+}
+
+// atValue returns the value of the first line starting with prefix, like
+// +CPIN: READY.
+func atValue(lines []string, prefix string) (string, bool) {
+	for _, l := range lines {
+		if strings.HasPrefix(l, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(l, prefix)), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeModem answers AT commands from replies, echoing them like a modem with
+// E1. Unknown commands get ERROR.
+type fakeModem struct {
+	replies map[string]string
+	sent    []string
+	in      bytes.Buffer
+	out     bytes.Buffer
+}
+
+func (m *fakeModem) Write(b []byte) (int, error) {
+	m.in.Write(b)
+	for {
+		s := m.in.String()
+		n := strings.IndexByte(s, '\r')
+		if n == -1 {
+			break
+		}
+		cmd := s[:n]
+		m.in.Next(n + 1)
+		m.sent = append(m.sent, cmd)
+		r, ok := m.replies[cmd]
+		if !ok {
+			r = "ERROR"
+		}
+		m.out.WriteString(cmd + "\r\r\n")
+		for _, l := range strings.Split(r, "\n") {
+			m.out.WriteString(l + "\r\n")
+		}
+	}
+	return len(b), nil
+}
+
+func (m *fakeModem) Read(b []byte) (int, error) {
+	return m.out.Read(b)
+}
+
+func TestATCommand(t *testing.T) {
+	m := &fakeModem{replies: map[string]string{
+		"AT+CIMI":   "\n639030000000002\n\nOK",
+		"AT+CPIN=1": "+CME ERROR: incorrect password",
+	}}
+	p := NewATPort(m)
+	lines, err := p.Command("AT+CIMI")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"639030000000002"}) {
+		t.Errorf("unexpected reply %q", lines)
+	}
+	_, err = p.Command("AT+CPIN=1")
+	e, ok := err.(*ATError)
+	if !ok || e.Reply != "+CME ERROR: incorrect password" {
+		t.Errorf("expected an ATError got %v", err)
+	}
+	_, err = p.Command("AT+BOGUS")
+	if _, ok := err.(*ATError); !ok {
+		t.Errorf("expected an ATError got %v", err)
+	}
+	v, ok := atValue([]string{"^RSSI: 12", "+CPIN: SIM PIN"}, "+CPIN:")
+	if !ok || v != "SIM PIN" {
+		t.Errorf("expected SIM PIN got %q", v)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	iwGroupRe = regexp.MustCompile(`#\{([^}]*)\}\s*<=\s*(\d+)`)
+	iwTotalRe = regexp.MustCompile(`total\s*<=\s*(\d+)`)
+	iwPhyRe   = regexp.MustCompile(`(?m)^\s*wiphy\s+(\d+)`)
+)
+
+//SupportsAPAndStation returns true if the output of iw phy info lists an
+//interface combination with both a managed and an AP interface.
+func SupportsAPAndStation(iwInfo string) bool {
+	for _, c := range iwCombinations(iwInfo) {
+		total := 0
+		if m := iwTotalRe.FindStringSubmatch(c); m != nil {
+			total, _ = strconv.Atoi(m[1])
+		}
+		if total < 2 {
+			continue
+		}
+		managed, ap := -1, -1
+		limits := make(map[int]int)
+		for k, g := range iwGroupRe.FindAllStringSubmatch(c, -1) {
+			limits[k], _ = strconv.Atoi(g[2])
+			for _, t := range strings.Split(g[1], ",") {
+				switch strings.TrimSpace(t) {
+				case "managed":
+					managed = k
+				case "AP":
+					ap = k
+				}
+			}
+		}
+		if managed == -1 || ap == -1 {
+			continue
+		}
+		if managed == ap && limits[managed] >= 2 {
+			return true
+		}
+		if managed != ap && limits[managed] >= 1 && limits[ap] >= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// iwCombinations returns the valid interface combinations listed by iw phy
+// info, one string per combination.
+func iwCombinations(iwInfo string) []string {
+	var result []string
+	lines := strings.Split(iwInfo, "\n")
+	for k, l := range lines {
+		if !strings.Contains(l, "valid interface combinations:") {
+			continue
+		}
+		indent := len(l) - len(strings.TrimLeft(l, " \t"))
+		var c string
+		for _, v := range lines[k+1:] {
+			t := strings.TrimSpace(v)
+			if t == "" || len(v)-len(strings.TrimLeft(v, " \t")) <= indent {
+				break
+			}
+			if strings.HasPrefix(t, "*") {
+				if c != "" {
+					result = append(result, c)
+				}
+				c = strings.TrimSpace(strings.TrimPrefix(t, "*"))
+				continue
+			}
+			c += " " + t
+		}
+		if c != "" {
+			result = append(result, c)
+		}
+		break
+	}
+	return result
+}
+
+// checkConcurrentSupport returns an error if the radio behind interface i can
+// not run a managed and an AP interface at the same time.
+func checkConcurrentSupport(i string) error {
+	o, err := exec.Command("iw", "dev", i, "info").Output()
+	if err != nil {
+		return fmt.Errorf("fconf: running iw dev %s info %v", i, err)
+	}
+	m := iwPhyRe.FindStringSubmatch(string(o))
+	if m == nil {
+		return fmt.Errorf("fconf: can not find the radio of %s", i)
+	}
+	o, err = exec.Command("iw", "phy", "phy"+m[1], "info").Output()
+	if err != nil {
+		return fmt.Errorf("fconf: running iw phy phy%s info %v", m[1], err)
+	}
+	if !SupportsAPAndStation(string(o)) {
+		return fmt.Errorf("fconf: the driver of %s can not be a wifi client and an access point at the same time", i)
+	}
+	return nil
+}
+
+// FreqToChannel returns the channel number and band of a frequency in MHz.
+func FreqToChannel(freq int) (int, float64, error) {
+	switch {
+	case freq == 2484:
+		return 14, 2.4, nil
+	case freq >= 2412 && freq <= 2472:
+		return (freq - 2407) / 5, 2.4, nil
+	case freq >= 5160 && freq <= 5885:
+		return (freq - 5000) / 5, 5, nil
+	}
+	return 0, 0, fmt.Errorf("fconf: unsupported frequency %d", freq)
+}
+
+// virtualAPIface returns the name of the virtual AP interface created on top
+// of i. Interface names are limited to 15 characters.
+func virtualAPIface(i string) string {
+	if len(i) > 13 {
+		i = i[:13]
+	}
+	return i + "ap"
+}
+
+// virtualMAC derives a locally administered address for the virtual AP
+// interface from the address of the radio, the same way create_ap does by
+// changing the last byte.
+func virtualMAC(hw net.HardwareAddr) (string, error) {
+	if len(hw) != 6 {
+		return "", errors.New("fconf: bad hardware address")
+	}
+	m := make(net.HardwareAddr, 6)
+	copy(m, hw)
+	m[0] |= 0x02
+	m[5]++
+	return m.String(), nil
+}
+
+// prepareConcurrentAP sets up a so that it runs next to the wifi client on the
+// same radio. The access point has to use the channel of the network the
+// client is connected to.
+func prepareConcurrentAP(a *AccessPoint) error {
+	i := a.WifiIface
+	err := checkConcurrentSupport(i)
+	if err != nil {
+		return err
+	}
+	connected := false
+	c, err := DialWPACtrl(wpaCtrlInterface, i)
+	if err == nil {
+		s, serr := c.Status()
+		_ = c.Close()
+		if serr == nil && s.WPAState == "COMPLETED" && s.Frequency != 0 {
+			ch, band, err := FreqToChannel(s.Frequency)
+			if err != nil {
+				return err
+			}
+			a.Channel = fmt.Sprint(ch)
+			a.FreqBand = band
+			a.clientChannel = true
+			connected = true
+		}
+	}
+	if !connected {
+		fmt.Printf("WARN: wifi client on %s is not connected, the access point may not start on channel %s\n",
+			i, a.Channel)
+	}
+	switch a.Backend {
+	case "", BackendCreateAP:
+		// create_ap creates the virtual interface itself.
+		a.NoVirt = 0
+	case BackendHostapd:
+		a.VirtIface = virtualAPIface(i)
+		if a.NewMACAddr == "" {
+			n, err := net.InterfaceByName(i)
+			if err != nil {
+				return err
+			}
+			a.NewMACAddr, err = virtualMAC(n.HardwareAddr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
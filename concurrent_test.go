@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+func TestSupportsAPAndStation(t *testing.T) {
+	sample := []struct {
+		file      string
+		supported bool
+	}{
+		{"fixture/iw_phy_brcmfmac.txt", true},
+		{"fixture/iw_phy_noap.txt", false},
+	}
+	for _, v := range sample {
+		b, err := ioutil.ReadFile(v.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if SupportsAPAndStation(string(b)) != v.supported {
+			t.Errorf("%s: expected %v", v.file, v.supported)
+		}
+	}
+	if !SupportsAPAndStation("\tvalid interface combinations:\n\t\t * #{ managed, AP } <= 2,\n\t\t   total <= 2, #channels <= 1\n") {
+		t.Error("expected managed and AP in the same group to be supported")
+	}
+}
+
+func TestFreqToChannel(t *testing.T) {
+	sample := []struct {
+		freq, channel int
+		band          float64
+	}{
+		{2412, 1, 2.4},
+		{2437, 6, 2.4},
+		{2472, 13, 2.4},
+		{2484, 14, 2.4},
+		{5180, 36, 5},
+		{5745, 149, 5},
+	}
+	for _, v := range sample {
+		c, b, err := FreqToChannel(v.freq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != v.channel || b != v.band {
+			t.Errorf("%d: expected %d %v got %d %v", v.freq, v.channel, v.band, c, b)
+		}
+	}
+	_, _, err := FreqToChannel(60480)
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestVirtualAP(t *testing.T) {
+	if i := virtualAPIface("wlx00c0ca123456"); i != "wlx00c0ca1234ap" {
+		t.Errorf("expected wlx00c0ca1234ap got %s", i)
+	}
+	hw, _ := net.ParseMAC("b8:27:eb:12:34:56")
+	m, err := virtualMAC(hw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != "ba:27:eb:12:34:57" {
+		t.Errorf("expected ba:27:eb:12:34:57 got %s", m)
+	}
+
+	a := DefaultAccesPoint()
+	a.Update(&AccessPointConfig{
+		Interface:        "wlan0",
+		Channel:          6,
+		ShareInterfaec:   "wlan0",
+		Backend:          BackendHostapd,
+		Firewall:         FirewallNftables,
+		Concurrent:       true,
+		VirtualInterface: "wlan0ap",
+		NewMACAddr:       &m,
+	})
+	u, err := hostapdUnit{a}.ToSystemdUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _ := ioutil.ReadAll(unit.Serialize(u))
+	exp, err := ioutil.ReadFile("fixture/fconf-hostapd-concurrent.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, o) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), string(o))
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/urfave/cli"
 )
@@ -250,3 +251,24 @@ func stateDir() string {
 	}
 	return dir
 }
+
+// lockState takes an exclusive lock named name in the state directory, so
+// that fconf processes change the state guarded by it one at a time. Closing
+// the returned file releases the lock.
+func lockState(name string) (*os.File, error) {
+	dir := stateDir()
+	err := checkDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, name+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
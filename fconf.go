@@ -27,6 +27,33 @@ const (
 	removeFlag      = "remove"
 	configFlag      = "config"
 	fconfConfigDir  = "/etc/fconf"
+
+	addNetworkFlag     = "add-network"
+	removeNetworkFlag  = "remove-network"
+	scanFlag           = "scan"
+	statusFlag         = "status"
+	allowFlag          = "allow"
+	denyFlag           = "deny"
+	listMACsFlag       = "list-macs"
+	clientsFlag        = "clients"
+	importFlag         = "import"
+	acceptFlag         = "accept"
+	revokeFlag         = "revoke"
+	listFlag           = "list"
+	serveFlag          = "serve"
+	applyScheduleFlag  = "apply-schedule"
+	limitsFlag         = "limits"
+	mmConnectFlag      = "mm-connect"
+	mmDisconnectFlag   = "mm-disconnect"
+	listAPNsFlag       = "list-apns"
+	simStatusFlag      = "sim-status"
+	unlockSIMFlag      = "unlock-sim"
+	wwanConnectFlag    = "wwan-connect"
+	wwanDisconnectFlag = "wwan-disconnect"
+	probeFlag          = "probe"
+	sampleFlag         = "sample"
+	runFlag            = "run"
+	wpaSupplicantDir   = "/etc/wpa_supplicant/"
 )
 
 //Ethernet is the ehternet configuration.
@@ -45,8 +72,117 @@ func (e Ethernet) ToSystemdUnit() ([]*unit.UnitOption, error) {
 //Wifi is the wifi configuration.
 type Wifi struct {
 	Network
-	Username string `json:"ssid"`
-	Password string `json:"passphrase"`
+	Username string   `json:"ssid"`
+	Password string   `json:"passphrase"`
+	Security string   `json:"security,omitempty"`
+	EAP      *WifiEAP `json:"eap,omitempty"`
+
+	// Country is the ISO 3166-1 alpha-2 code used by wpa_supplicant to pick
+	// the regulatory domain.
+	Country string `json:"country,omitempty"`
+
+	// Networks are additional saved networks. wpa_supplicant picks the one
+	// with the highest priority among those in range.
+	Networks []*WifiNetwork `json:"networks,omitempty"`
+}
+
+//WifiNetwork is a saved wifi network.
+type WifiNetwork struct {
+	SSID       string `json:"ssid"`
+	Passphrase string `json:"passphrase"`
+	Priority   int    `json:"priority"`
+	Hidden     bool   `json:"hidden"`
+	BSSID      string `json:"bssid,omitempty"`
+
+	// Security is one of open, wpa-psk, sae or wpa-eap. When empty wpa-psk
+	// is used if there is a passphrase and open otherwise.
+	Security string   `json:"security,omitempty"`
+	EAP      *WifiEAP `json:"eap,omitempty"`
+}
+
+// Supported values of WifiNetwork.Security
+const (
+	SecurityOpen   = "open"
+	SecurityWPAPSK = "wpa-psk"
+	SecuritySAE    = "sae"
+	SecurityWPAEAP = "wpa-eap"
+)
+
+//WifiEAP is the 802.1X configuration of a wpa-eap network.
+type WifiEAP struct {
+	// Method is one of peap, ttls or tls.
+	Method            string `json:"method"`
+	Identity          string `json:"identity"`
+	AnonymousIdentity string `json:"anonymous_identity,omitempty"`
+	Password          string `json:"password,omitempty"`
+
+	// Phase2 is the inner authentication for peap and ttls, defaults to
+	// MSCHAPV2.
+	Phase2 string `json:"phase2,omitempty"`
+
+	CACert             string `json:"ca_cert,omitempty"`
+	ClientCert         string `json:"client_cert,omitempty"`
+	PrivateKey         string `json:"private_key,omitempty"`
+	PrivateKeyPassword string `json:"private_key_password,omitempty"`
+}
+
+//SecurityMode returns the security mode of the network.
+func (n *WifiNetwork) SecurityMode() string {
+	if n.Security != "" {
+		return strings.ToLower(n.Security)
+	}
+	if n.EAP != nil {
+		return SecurityWPAEAP
+	}
+	if n.Passphrase != "" {
+		return SecurityWPAPSK
+	}
+	return SecurityOpen
+}
+
+//SavedNetworks returns all networks of w. The network specified by the ssid
+//and passphrase fields comes first.
+func (w Wifi) SavedNetworks() []*WifiNetwork {
+	var n []*WifiNetwork
+	if w.Username != "" {
+		n = append(n, &WifiNetwork{
+			SSID:       w.Username,
+			Passphrase: w.Password,
+			Security:   w.Security,
+			EAP:        w.EAP,
+		})
+	}
+	return append(n, w.Networks...)
+}
+
+//AddNetwork adds n to the saved networks, replacing any network with the same
+//ssid.
+func (w *Wifi) AddNetwork(n *WifiNetwork) {
+	w.RemoveNetwork(n.SSID)
+	w.Networks = append(w.Networks, n)
+}
+
+//RemoveNetwork removes the network with the given ssid. It returns false if
+//there was no such network.
+func (w *Wifi) RemoveNetwork(ssid string) bool {
+	found := false
+	if ssid != "" && w.Username == ssid {
+		w.Username = ""
+		w.Password = ""
+		w.Security = ""
+		w.EAP = nil
+		found = true
+	}
+	var n []*WifiNetwork
+	for _, v := range w.Networks {
+		if v.SSID == ssid {
+			found = true
+			continue
+		}
+		n = append(n, v)
+	}
+	w.Networks = n
+	return found
 }
 
 //UnitFile is an interface for systemd uni file
@@ -104,6 +240,11 @@ func restartService(name string) error {
 	return systemdCMD("restart", name)
 }
 
+// tryRestartService restarts the service only if it is running.
+func tryRestartService(name string) error {
+	return systemdCMD("try-restart", name)
+}
+
 func startService(name string) error {
 	return systemdCMD("start", name)
 }
@@ -119,6 +260,17 @@ func stopService(name string) error {
 	return systemdCMD("stop", name)
 }
 
+func reloadSystemd() error {
+	fmt.Print("daemon-reload ...")
+	_, err := exec.Command("systemctl", "daemon-reload").Output()
+	if err != nil {
+		fmt.Println("done with error")
+		return err
+	}
+	fmt.Println("done without error")
+	return nil
+}
+
 func systemdCMD(name, service string) error {
 	fmt.Printf("%s %s ...", name, service)
 	_, err := exec.Command("systemctl", name, service).Output()
@@ -161,6 +313,25 @@ func ListInterface(ctx *cli.Context) error {
 	return nil
 }
 
+// interfaceIPv4 returns the first IPv4 address of interface i, or an empty
+// string if there is none.
+func interfaceIPv4(i string) string {
+	n, err := net.InterfaceByName(i)
+	if err != nil {
+		return ""
+	}
+	addrs, err := n.Addrs()
+	if err != nil {
+		return ""
+	}
+	for _, v := range addrs {
+		if ip, ok := v.(*net.IPNet); ok && ip.IP.To4() != nil {
+			return ip.IP.String()
+		}
+	}
+	return ""
+}
+
 func getFlags(f net.Flags) []string {
 	return strings.Split(f.String(), "|")
 }
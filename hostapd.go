@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// Access point backends.
+const (
+	BackendCreateAP = "create_ap"
+	BackendHostapd  = "hostapd"
+)
+
+// Firewalls used to setup NAT with the hostapd backend.
+const (
+	FirewallIptables = "iptables"
+	FirewallNftables = "nftables"
+)
+
+const (
+	hostapdConfig   = "/etc/hostapd/fconf-%s.conf"
+	hostapdCtrl     = "/run/hostapd"
+	dnsmasqConfig   = "/etc/fconf-dnsmasq-%s.conf"
+	dnsmasqLeases   = "/var/lib/misc/fconf-dnsmasq-%s.leases"
+	nftablesConfig  = "/etc/fconf-nat-%s.nft"
+	systemdBase     = "/etc/systemd/system"
+	hostapdService  = "fconf-hostapd-%s.service"
+	dnsmasqService  = "fconf-dnsmasq-%s.service"
+	hostapdNftTable = "fconf_ap_%s"
+
+	// dhcpFirst and dhcpLast are the host numbers of the addresses leased to
+	// clients.
+	dhcpFirst = 1
+	dhcpLast  = 254
+)
+
+var hostapdTpl = template.Must(template.New("hostapd").Parse(`interface={{.Iface}}
+driver={{.Driver}}
+ctrl_interface={{.Ctrl}}
+ssid={{.SSID}}
+hw_mode={{.HWMode}}
+channel={{.ChannelNumber}}
+{{- if .Country}}
+country_code={{.Country}}
+ieee80211d=1
+{{- end}}
+{{- if .IEEE80211N}}
+ieee80211n=1
+{{- if .HTCapAb}}
+ht_capab={{.HTCapAb}}
+{{- end}}
+{{- end}}
+{{- if .IEEE80211AC}}
+ieee80211ac=1
+{{- if .VHTCapAb}}
+vht_capab={{.VHTCapAb}}
+{{- end}}
+{{- end}}
+{{- if .Hidden}}
+ignore_broadcast_ssid=1
+{{- end}}
+{{- if .IsolateClients}}
+ap_isolate=1
+{{- end}}
+{{- if .MACFilter}}
+macaddr_acl=1
+accept_mac_file={{.MACFilterAccept}}
+{{- end}}
+{{- if .NewMACAddr}}
+bssid={{.NewMACAddr}}
+{{- end}}
+{{- if .Passphrase}}
+wpa={{.WPA}}
+wpa_key_mgmt=WPA-PSK
+wpa_pairwise=TKIP CCMP
+rsn_pairwise=CCMP
+{{- if .UsePsk}}
+wpa_psk={{.Passphrase}}
+{{- else}}
+wpa_passphrase={{.Passphrase}}
+{{- end}}
+{{- end}}
+`))
+
+var dnsmasqTpl = template.Must(template.New("dnsmasq").Parse(`interface={{.Iface}}
+bind-interfaces
+listen-address={{.Gateway}}
+dhcp-range={{.RangeFirst}},{{.RangeLast}},255.255.255.0,24h
+dhcp-option-force=option:router,{{.Gateway}}
+dhcp-option-force=option:dns-server,{{.DNS}}
+dhcp-leasefile={{.Leases}}
+{{- if not .ETCHosts}}
+no-hosts
+{{- end}}
+{{- if .NoDNS}}
+port=0
+{{- end}}
+`))
+
+var nftablesTpl = template.Must(template.New("nftables").Parse(`table ip {{.Table}} {
+	chain postrouting {
+		type nat hook postrouting priority 100; policy accept;
+		ip saddr {{.Subnet}}.0/24 oifname "{{.InternetIface}}" masquerade
+	}
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+		iifname "{{.Iface}}" ip saddr {{.Subnet}}.0/24 accept
+		iifname "{{.InternetIface}}" ip daddr {{.Subnet}}.0/24 accept
+	}
+}
+`))
+
+type hostapdContext struct {
+	*AccessPoint
+	Iface         string
+	Ctrl          string
+	WPA           int
+	HWMode        string
+	ChannelNumber string
+	Subnet        string
+	RangeFirst    string
+	RangeLast     string
+	DNS           string
+	Leases        string
+	Table         string
+}
+
+// dhcpRange returns the first and last address leased to the clients in the
+// /24 network of the gateway. dnsmasq is configured with it, and create_ap
+// uses the same range.
+func (a *AccessPoint) dhcpRange() (first, last net.IP, err error) {
+	ip := net.ParseIP(a.Gateway).To4()
+	if ip == nil {
+		return nil, nil, fmt.Errorf("fconf: bad gateway %s", a.Gateway)
+	}
+	first = net.IPv4(ip[0], ip[1], ip[2], dhcpFirst).To4()
+	last = net.IPv4(ip[0], ip[1], ip[2], dhcpLast).To4()
+	return first, last, nil
+}
+
+func (a *AccessPoint) hostapdContext() (*hostapdContext, error) {
+	ip := net.ParseIP(a.Gateway).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("fconf: bad gateway %s", a.Gateway)
+	}
+	first, last, err := a.dhcpRange()
+	if err != nil {
+		return nil, err
+	}
+	ctx := &hostapdContext{
+		AccessPoint:   a,
+		Iface:         a.apIface(),
+		Ctrl:          hostapdCtrl,
+		WPA:           a.WPAVersion,
+		HWMode:        "g",
+		ChannelNumber: a.Channel,
+		Subnet:        fmt.Sprintf("%d.%d.%d", ip[0], ip[1], ip[2]),
+		RangeFirst:    first.String(),
+		RangeLast:     last.String(),
+		DNS:           a.DHCPDNS,
+		Leases:        fmt.Sprintf(dnsmasqLeases, a.WifiIface),
+		Table:         fmt.Sprintf(hostapdNftTable, a.WifiIface),
+	}
+	if ctx.WPA == 0 {
+		ctx.WPA = 2
+	}
+	if a.FreqBand == 5 {
+		ctx.HWMode = "a"
+	}
+	if ctx.ChannelNumber == "" || ctx.ChannelNumber == "default" {
+		ctx.ChannelNumber = "1"
+		if a.FreqBand == 5 {
+			ctx.ChannelNumber = "36"
+		}
+	}
+	if ctx.DNS == "" || ctx.DNS == "gateway" {
+		ctx.DNS = a.Gateway
+	}
+	return ctx, nil
+}
+
+//WriteHostapd writes hostapd configuration of the access point to dst.
+func (a *AccessPoint) WriteHostapd(dst io.Writer) (int64, error) {
+	return a.execTemplate(hostapdTpl, dst)
+}
+
+//WriteDnsmasq writes dnsmasq configuration for the DHCP and DNS server of
+//the access point to dst.
+func (a *AccessPoint) WriteDnsmasq(dst io.Writer) (int64, error) {
+	return a.execTemplate(dnsmasqTpl, dst)
+}
+
+//WriteNftables writes an nftables ruleset that shares the internet interface
+//with the access point clients, the same way create_ap does with iptables.
+func (a *AccessPoint) WriteNftables(dst io.Writer) (int64, error) {
+	return a.execTemplate(nftablesTpl, dst)
+}
+
+func (a *AccessPoint) execTemplate(tpl *template.Template, dst io.Writer) (int64, error) {
+	ctx, err := a.hostapdContext()
+	if err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	err = tpl.Execute(&buf, ctx)
+	if err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(dst)
+}
+
+//IptablesRules returns the iptables arguments used to share the internet
+//interface with the access point clients. Each rule is inserted with -I, and
+//can be deleted by replacing -I with -D.
+func (a *AccessPoint) IptablesRules() ([][]string, error) {
+	ctx, err := a.hostapdContext()
+	if err != nil {
+		return nil, err
+	}
+	subnet := ctx.Subnet + ".0/24"
+	return [][]string{
+		{"-t", "nat", "-I", "POSTROUTING", "-s", subnet, "-o", a.InternetIface, "-j", "MASQUERADE"},
+		{"-I", "FORWARD", "-i", a.apIface(), "-s", subnet, "-j", "ACCEPT"},
+		{"-I", "FORWARD", "-i", a.InternetIface, "-d", subnet, "-j", "ACCEPT"},
+	}, nil
+}
+
+// hostapdUnit is the systemd service that runs hostapd for the access point.
+// It also assigns the gateway address to the interface and sets up NAT when
+// the internet interface is shared.
+type hostapdUnit struct {
+	*AccessPoint
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (h hostapdUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	a := h.AccessPoint
+	i := a.WifiIface
+	if i == "" {
+		return nil, errors.New("fconf: missing wifi interface")
+	}
+	u := []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf access point on "+i),
+		unit.NewUnitOption("Unit", "After", "network.target"),
+	}
+	ai := a.apIface()
+	if a.VirtIface != "" {
+		u = append(u,
+			unit.NewUnitOption("Unit", "After", "wpa_supplicant@"+i+".service"),
+			unit.NewUnitOption("Service", "ExecStartPre", "-/sbin/iw dev "+ai+" del"),
+			unit.NewUnitOption("Service", "ExecStartPre",
+				"/sbin/iw dev "+i+" interface add "+ai+" type __ap"),
+		)
+		if a.NewMACAddr != "" {
+			u = append(u, unit.NewUnitOption("Service", "ExecStartPre",
+				"/sbin/ip link set dev "+ai+" address "+a.NewMACAddr))
+		}
+	}
+	u = append(u,
+		unit.NewUnitOption("Service", "ExecStartPre", "/sbin/ip link set "+ai+" up"),
+		unit.NewUnitOption("Service", "ExecStartPre", "/sbin/ip addr flush dev "+ai),
+		unit.NewUnitOption("Service", "ExecStartPre", "/sbin/ip addr add "+a.Gateway+"/24 dev "+ai),
+	)
+	var up, down []string
+	if a.ShareMethod == "nat" && a.InternetIface != "" {
+		up = append(up, "/sbin/sysctl -w net.ipv4.ip_forward=1")
+		switch a.Firewall {
+		case FirewallNftables:
+			up = append(up, "/usr/sbin/nft -f "+fmt.Sprintf(nftablesConfig, i))
+			down = append(down, "-/usr/sbin/nft delete table ip "+fmt.Sprintf(hostapdNftTable, i))
+		case "", FirewallIptables:
+			rules, err := a.IptablesRules()
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range rules {
+				cmd := "/sbin/iptables " + strings.Join(r, " ")
+				up = append(up, cmd)
+				down = append(down, "-"+strings.Replace(cmd, " -I ", " -D ", 1))
+			}
+		default:
+			return nil, fmt.Errorf("fconf: unknown firewall %s", a.Firewall)
+		}
+	}
+	for _, v := range up {
+		u = append(u, unit.NewUnitOption("Service", "ExecStartPre", v))
+	}
+	u = append(u, unit.NewUnitOption("Service", "ExecStart",
+		"/usr/sbin/hostapd "+fmt.Sprintf(hostapdConfig, i)))
+	for _, v := range down {
+		u = append(u, unit.NewUnitOption("Service", "ExecStopPost", v))
+	}
+	u = append(u, unit.NewUnitOption("Service", "ExecStopPost", "-/sbin/ip addr flush dev "+ai))
+	if a.VirtIface != "" {
+		u = append(u, unit.NewUnitOption("Service", "ExecStopPost", "-/sbin/iw dev "+ai+" del"))
+	}
+	u = append(u, unit.NewUnitOption("Install", "WantedBy", "multi-user.target"))
+	return u, nil
+}
+
+// dnsmasqUnit is the systemd service that runs dnsmasq for the access point.
+type dnsmasqUnit struct {
+	*AccessPoint
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (d dnsmasqUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	i := d.WifiIface
+	if i == "" {
+		return nil, errors.New("fconf: missing wifi interface")
+	}
+	h := fmt.Sprintf(hostapdService, i)
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf access point DHCP and DNS on "+i),
+		unit.NewUnitOption("Unit", "BindsTo", h),
+		unit.NewUnitOption("Unit", "After", h),
+		unit.NewUnitOption("Service", "ExecStart",
+			"/usr/sbin/dnsmasq -k -C "+fmt.Sprintf(dnsmasqConfig, i)),
+		unit.NewUnitOption("Install", "WantedBy", h),
+	}, nil
+}
+
+// apServices returns the systemd services that make up the access point, in
+// the order they should be started.
+func apServices(c *AccessPointConfig) []string {
+	var s []string
+	if c.Backend == BackendHostapd {
+		s = []string{
+			fmt.Sprintf(hostapdService, c.Interface),
+			fmt.Sprintf(dnsmasqService, c.Interface),
+		}
+	} else {
+		s = []string{"create_ap@" + c.Interface}
+	}
+	if c.Portal != nil {
+		s = append(s, fmt.Sprintf(portalService, c.Interface))
+	}
+	if c.Limits != nil {
+		s = append(s, fmt.Sprintf(apLimitsService, c.Interface))
+	}
+	return s
+}
+
+// writeHostapdBackend writes hostapd and dnsmasq configuration and the
+// systemd services that run them.
+func writeHostapdBackend(a *AccessPoint) error {
+	type apFile struct {
+		name  string
+		mode  os.FileMode
+		write func(io.Writer) (int64, error)
+	}
+	i := a.WifiIface
+	files := []apFile{
+		{fmt.Sprintf(hostapdConfig, i), 0600, a.WriteHostapd},
+		{fmt.Sprintf(dnsmasqConfig, i), 0644, a.WriteDnsmasq},
+	}
+	if a.Firewall == FirewallNftables {
+		files = append(files,
+			apFile{fmt.Sprintf(nftablesConfig, i), 0644, a.WriteNftables})
+	}
+	for _, f := range files {
+		var buf bytes.Buffer
+		_, err := f.write(&buf)
+		if err != nil {
+			return err
+		}
+		err = checkDir(filepath.Dir(f.name))
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(f.name, buf.Bytes(), f.mode)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("successful written access point configuration to %s \n", f.name)
+	}
+	err := CreateSystemdFile(hostapdUnit{a},
+		filepath.Join(systemdBase, fmt.Sprintf(hostapdService, i)), 0644)
+	if err != nil {
+		return err
+	}
+	err = CreateSystemdFile(dnsmasqUnit{a},
+		filepath.Join(systemdBase, fmt.Sprintf(dnsmasqService, i)), 0644)
+	if err != nil {
+		return err
+	}
+	return reloadSystemd()
+}
+
+// hostapdFiles returns the files written by the hostapd backend for
+// interface i.
+func hostapdFiles(i string) []string {
+	return []string{
+		fmt.Sprintf(hostapdConfig, i),
+		fmt.Sprintf(dnsmasqConfig, i),
+		fmt.Sprintf(nftablesConfig, i),
+		filepath.Join(systemdBase, fmt.Sprintf(hostapdService, i)),
+		filepath.Join(systemdBase, fmt.Sprintf(dnsmasqService, i)),
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+func TestAccessPoint_hostapd(t *testing.T) {
+	a := DefaultAccesPoint()
+	a.Update(&AccessPointConfig{
+		Interface:      "wlan0",
+		SSID:           "voxbox",
+		Passphrase:     "voxbox99",
+		Channel:        6,
+		ShareInterfaec: "eth0",
+		Backend:        BackendHostapd,
+		Firewall:       FirewallNftables,
+	})
+	sample := []struct {
+		golden string
+		write  func(io.Writer) (int64, error)
+	}{
+		{"fixture/hostapd.conf", a.WriteHostapd},
+		{"fixture/dnsmasq.conf", a.WriteDnsmasq},
+		{"fixture/nat.nft", a.WriteNftables},
+	}
+	for _, v := range sample {
+		var buf bytes.Buffer
+		_, err := v.write(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, err := ioutil.ReadFile(v.golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(exp, buf.Bytes()) {
+			t.Errorf("%s: expected \n %s \n Got \n %s", v.golden, string(exp), buf.String())
+		}
+	}
+
+	a.Firewall = FirewallIptables
+	u, err := hostapdUnit{a}.ToSystemdUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _ := ioutil.ReadAll(unit.Serialize(u))
+	exp, err := ioutil.ReadFile("fixture/fconf-hostapd.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, o) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), string(o))
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/urfave/cli"
+)
+
+const (
+	apLimitsService = "fconf-ap-limits-%s.service"
+	apLimitsBatch   = "/etc/fconf-tc-%s.batch"
+
+	// unlimitedRate is used for the root class when there is no aggregate
+	// limit, htb classes must have a rate.
+	unlimitedRate = 1000000
+)
+
+//BandwidthLimit is a rate limit in kbit/s, zero means unlimited.
+type BandwidthLimit struct {
+	Download int `json:"download,omitempty"`
+	Upload   int `json:"upload,omitempty"`
+}
+
+//APLimits are the bandwidth limits of the access point clients. Total is
+//shared by all clients, PerClient applies to every client unless there is an
+//entry for its address in Clients.
+type APLimits struct {
+	Total     BandwidthLimit             `json:"total"`
+	PerClient BandwidthLimit             `json:"per_client"`
+	Clients   map[string]*BandwidthLimit `json:"clients,omitempty"`
+}
+
+// ifbName returns the name of the ifb device used to shape the traffic
+// received on interface i.
+func ifbName(i string) string {
+	n := "ifb" + i
+	if len(n) > 15 {
+		n = n[:15]
+	}
+	return n
+}
+
+// clientLimit returns the limit of the client with address ip.
+func (l *APLimits) clientLimit(ip string) BandwidthLimit {
+	if c, ok := l.Clients[ip]; ok {
+		return *c
+	}
+	return l.PerClient
+}
+
+//WriteTC writes the tc batch file that applies the bandwidth limits. Traffic
+//sent to the clients is shaped on the access point interface, and traffic
+//from the clients on the ifb device it is redirected to.
+//
+//The classes of the limited clients and the default class share the total
+//rate, htb always grants a class its rate, and borrow up to their limit, so
+//that the clients together never get more than the total.
+func (a *AccessPoint) WriteTC(dst io.Writer) (int64, error) {
+	if a.Limits == nil {
+		return 0, errors.New("fconf: bandwidth limits are not configured")
+	}
+	gw := net.ParseIP(a.Gateway).To4()
+	if gw == nil {
+		return 0, fmt.Errorf("fconf: bad gateway %s", a.Gateway)
+	}
+	first, last, err := a.dhcpRange()
+	if err != nil {
+		return 0, err
+	}
+	ai := a.apIface()
+	ifb := ifbName(a.WifiIface)
+	var buf bytes.Buffer
+	htb := func(dev, match string, total int, limit func(BandwidthLimit) int) {
+		if total == 0 {
+			total = unlimitedRate
+		}
+		type client struct {
+			host  string
+			id    string
+			limit int
+		}
+		var clients []client
+		for h := int(first[3]); h <= int(last[3]); h++ {
+			if h == int(gw[3]) {
+				continue
+			}
+			host := fmt.Sprintf("%d.%d.%d.%d", gw[0], gw[1], gw[2], h)
+			l := limit(a.Limits.clientLimit(host))
+			if l == 0 {
+				continue
+			}
+			if l > total {
+				l = total
+			}
+			clients = append(clients, client{host, fmt.Sprintf("1:%x", 0x100+h), l})
+		}
+		// the guaranteed rate of each class in bit/s, the default class
+		// is one of them.
+		share := total * 1000 / (len(clients) + 1)
+		fmt.Fprintf(&buf, "qdisc add dev %s root handle 1: htb default 10\n", dev)
+		fmt.Fprintf(&buf, "class add dev %s parent 1: classid 1:1 htb rate %dkbit ceil %dkbit\n", dev, total, total)
+		fmt.Fprintf(&buf, "class add dev %s parent 1:1 classid 1:10 htb rate %dbit ceil %dkbit\n", dev, share, total)
+		for _, c := range clients {
+			rate := share
+			if rate > c.limit*1000 {
+				rate = c.limit * 1000
+			}
+			fmt.Fprintf(&buf, "class add dev %s parent 1:1 classid %s htb rate %dbit ceil %dkbit\n", dev, c.id, rate, c.limit)
+			fmt.Fprintf(&buf, "filter add dev %s parent 1: protocol ip prio 1 u32 match ip %s %s/32 flowid %s\n", dev, match, c.host, c.id)
+		}
+	}
+	htb(ai, "dst", a.Limits.Total.Download, func(l BandwidthLimit) int {
+		return l.Download
+	})
+	fmt.Fprintf(&buf, "qdisc add dev %s handle ffff: ingress\n", ai)
+	fmt.Fprintf(&buf, "filter add dev %s parent ffff: protocol ip u32 match u32 0 0 action mirred egress redirect dev %s\n", ai, ifb)
+	htb(ifb, "src", a.Limits.Total.Upload, func(l BandwidthLimit) int {
+		return l.Upload
+	})
+	return buf.WriteTo(dst)
+}
+
+// limitsUnit is the oneshot systemd service that applies the bandwidth limits
+// when the access point starts and removes them when it stops.
+type limitsUnit struct {
+	*AccessPoint
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (l limitsUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	i := l.WifiIface
+	if i == "" {
+		return nil, errors.New("fconf: missing wifi interface")
+	}
+	ap := apServices(l.State())[0]
+	if !strings.HasSuffix(ap, ".service") {
+		ap += ".service"
+	}
+	ai := l.apIface()
+	ifb := ifbName(i)
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf access point bandwidth limits on "+i),
+		unit.NewUnitOption("Unit", "BindsTo", ap),
+		unit.NewUnitOption("Unit", "After", ap),
+		unit.NewUnitOption("Service", "Type", "oneshot"),
+		unit.NewUnitOption("Service", "RemainAfterExit", "yes"),
+		unit.NewUnitOption("Service", "ExecStartPre", "-/sbin/tc qdisc del dev "+ai+" root"),
+		unit.NewUnitOption("Service", "ExecStartPre", "-/sbin/tc qdisc del dev "+ai+" ingress"),
+		unit.NewUnitOption("Service", "ExecStartPre", "-/sbin/ip link del "+ifb),
+		unit.NewUnitOption("Service", "ExecStartPre", "/sbin/ip link add "+ifb+" type ifb"),
+		unit.NewUnitOption("Service", "ExecStartPre", "/sbin/ip link set "+ifb+" up"),
+		unit.NewUnitOption("Service", "ExecStart", "/sbin/tc -batch "+fmt.Sprintf(apLimitsBatch, i)),
+		unit.NewUnitOption("Service", "ExecStop", "-/sbin/tc qdisc del dev "+ai+" root"),
+		unit.NewUnitOption("Service", "ExecStop", "-/sbin/tc qdisc del dev "+ai+" ingress"),
+		unit.NewUnitOption("Service", "ExecStop", "-/sbin/ip link del "+ifb),
+		unit.NewUnitOption("Install", "WantedBy", ap),
+	}, nil
+}
+
+// writeLimits writes the tc batch file and the systemd service of the
+// bandwidth limits, or removes them when there are no limits.
+func writeLimits(a *AccessPoint) error {
+	i := a.WifiIface
+	service := filepath.Join(systemdBase, fmt.Sprintf(apLimitsService, i))
+	batch := fmt.Sprintf(apLimitsBatch, i)
+	if a.Limits == nil {
+		for _, f := range []string{service, batch} {
+			err := removeFile(f)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	_, err := a.WriteTC(&buf)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(batch, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("successful written bandwidth limits to %s \n", batch)
+	err = CreateSystemdFile(limitsUnit{a}, service, 0644)
+	if err != nil {
+		return err
+	}
+	return reloadSystemd()
+}
+
+// validateLimits checks that the limits are positive, and that client
+// entries are addresses of the access point network.
+func validateLimits(v *ValidationError, l *APLimits, gateway string) {
+	check := func(field string, b BandwidthLimit) {
+		if b.Download < 0 {
+			v.add(field+".download", "can not be negative")
+		}
+		if b.Upload < 0 {
+			v.add(field+".upload", "can not be negative")
+		}
+	}
+	check("limits.total", l.Total)
+	check("limits.per_client", l.PerClient)
+	gw := net.ParseIP(gateway).To4()
+	var ips []string
+	for k := range l.Clients {
+		ips = append(ips, k)
+	}
+	sort.Strings(ips)
+	for _, k := range ips {
+		field := "limits.clients." + k
+		ip := net.ParseIP(k).To4()
+		if ip == nil || gw == nil || !bytes.Equal(ip[:3], gw[:3]) ||
+			ip[3] == 0 || ip[3] == 255 || ip[3] == gw[3] {
+			v.add(field, "must be a client address of the access point network")
+			continue
+		}
+		if l.Clients[k] == nil {
+			v.add(field, "missing limit")
+			continue
+		}
+		check(field, *l.Clients[k])
+	}
+}
+
+//ListApLimitsCMD prints the bandwidth limits of the access point as json.
+func ListApLimitsCMD(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	state, err := accessPointState(i)
+	if err != nil {
+		return err
+	}
+	l := state.Configg.Limits
+	if l == nil {
+		l = &APLimits{}
+	}
+	if l.Clients == nil {
+		l.Clients = make(map[string]*BandwidthLimit)
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAccessPoint_WriteTC(t *testing.T) {
+	a := DefaultAccesPoint()
+	a.Limits = &APLimits{
+		Total: BandwidthLimit{Download: 2048},
+		Clients: map[string]*BandwidthLimit{
+			"192.168.12.20": {Download: 512, Upload: 128},
+			"192.168.12.21": {Upload: 64},
+		},
+	}
+	var buf bytes.Buffer
+	_, err := a.WriteTC(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ioutil.ReadFile("fixture/fconf-tc.batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, buf.Bytes()) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), buf.String())
+	}
+	checkTCRates(t, buf.String())
+
+	// every client but the gateway gets a class in both directions.
+	a.Limits = &APLimits{PerClient: BandwidthLimit{Download: 1024, Upload: 256}}
+	buf.Reset()
+	_, err = a.WriteTC(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := strings.Count(buf.String(), "ceil 1024kbit")
+	if n != 253 {
+		t.Errorf("expected 253 download classes got %d", n)
+	}
+	checkTCRates(t, buf.String())
+	if strings.Contains(buf.String(), "192.168.12.1/32") {
+		t.Error("expected no limit on the gateway")
+	}
+}
+
+// checkTCRates checks that the rates of the classes of each device add up to
+// at most the rate of their parent, htb grants them without looking at the
+// parent.
+func checkTCRates(t *testing.T, batch string) {
+	t.Helper()
+	rates := make(map[string]int64)
+	children := make(map[string]int64)
+	for _, l := range strings.Split(batch, "\n") {
+		f := strings.Fields(l)
+		if len(f) < 11 || f[0] != "class" {
+			continue
+		}
+		dev, parent, id, rate := f[3], f[5], f[7], f[10]
+		var bits int64
+		var err error
+		if strings.HasSuffix(rate, "kbit") {
+			bits, err = strconv.ParseInt(strings.TrimSuffix(rate, "kbit"), 10, 64)
+			bits *= 1000
+		} else {
+			bits, err = strconv.ParseInt(strings.TrimSuffix(rate, "bit"), 10, 64)
+		}
+		if err != nil {
+			t.Fatalf("bad rate in %s", l)
+		}
+		rates[dev+" "+id] = bits
+		children[dev+" "+parent] += bits
+	}
+	for k, sum := range children {
+		if r, ok := rates[k]; ok && sum > r {
+			t.Errorf("%s: the classes get %dbit, more than %dbit", k, sum, r)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+
+	"github.com/urfave/cli"
+)
+
+//AllowMAC adds mac to the list of stations allowed to connect to the access
+//point, and turns MAC filtering on.
+func (a *AccessPoint) AllowMAC(mac string) error {
+	m, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	for _, v := range a.AllowedMACs {
+		if v == m.String() {
+			a.MACFilter = 1
+			return nil
+		}
+	}
+	a.AllowedMACs = append(a.AllowedMACs, m.String())
+	a.MACFilter = 1
+	return nil
+}
+
+//DenyMAC removes mac from the list of allowed stations. It does not block a
+//station while MAC filtering is off, create_ap has no deny list. The last
+//station can only be removed once MAC filtering is turned off, so that the
+//access point neither opens to everyone nor locks everyone out.
+func (a *AccessPoint) DenyMAC(mac string) error {
+	m, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	var macs []string
+	found := false
+	for _, v := range a.AllowedMACs {
+		if v == m.String() {
+			found = true
+			continue
+		}
+		macs = append(macs, v)
+	}
+	if !found {
+		return fmt.Errorf("fconf: %s is not in the allowed list", m)
+	}
+	if len(macs) == 0 && a.MACFilter == 1 {
+		return fmt.Errorf("fconf: %s is the last allowed station, turn mac_filter off first", m)
+	}
+	a.AllowedMACs = macs
+	return nil
+}
+
+// writeMACAccept writes the list of allowed stations to the accept file used
+// by hostapd.
+func writeMACAccept(a *AccessPoint) error {
+	if a.MACFilterAccept == "" {
+		return errors.New("fconf: missing mac filter accept file")
+	}
+	var buf bytes.Buffer
+	for _, v := range a.AllowedMACs {
+		fmt.Fprintln(&buf, v)
+	}
+	err := checkDir(filepath.Dir(a.MACFilterAccept))
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(a.MACFilterAccept, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("successful written allowed stations to %s \n", a.MACFilterAccept)
+	return nil
+}
+
+//ApMACFilterCMD adds or removes a station from the allowed list of the access
+//point. The access point is restarted if it is running, since hostapd only
+//reads the accept file on startup.
+func ApMACFilterCMD(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	state, err := accessPointState(i)
+	if err != nil {
+		return err
+	}
+	ap := DefaultAccesPoint()
+	ap.Update(state.Configg)
+	if ctx.IsSet(allowFlag) {
+		err = ap.AllowMAC(ctx.String(allowFlag))
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.IsSet(denyFlag) {
+		err = ap.DenyMAC(ctx.String(denyFlag))
+		if err != nil {
+			return err
+		}
+	}
+	if ap.MACFilter == 0 {
+		// hostapd ignores the file when filtering is off, keep it in sync
+		// with the list anyway.
+		err = writeMACAccept(ap)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeAccessPoint(ap, ctx.String("dir"), ctx.String("name"))
+	if err != nil {
+		return err
+	}
+	state.Configg = ap.State()
+	if state.Enabled {
+		// a scheduled access point may be off outside its windows.
+		for _, service := range apServices(state.Configg) {
+			err = tryRestartService(service)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return keepState(
+		fmt.Sprintf(defaultAccessPointConfig, i), data)
+}
+
+//ListApMACsCMD prints a json array of the stations allowed to connect to the
+//access point.
+func ListApMACsCMD(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	state, err := accessPointState(i)
+	if err != nil {
+		return err
+	}
+	macs := state.Configg.AllowedMACs
+	if macs == nil {
+		macs = []string{}
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"mac_filter": state.Configg.MACFilter != nil && *state.Configg.MACFilter,
+		"allowed":    macs,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
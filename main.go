@@ -14,6 +14,11 @@ const (
 	defaultFougGConfig       = "4g-ndis@%s.json"
 	defaultThreeGGConfig     = "3g-ras@%s.json"
 	defaultVoiceChanConfig   = "voice-channel@%s.json"
+	defaultPortalState       = "portal@%s.json"
+	defaultPINSecret         = "pin@%s"
+	defaultPINRejected       = "pin@%s.rejected"
+	defaultWWANState         = "wwan@%s.json"
+	defaultUsageState        = "usage@%s.json"
 )
 
 func main() {
@@ -89,6 +94,30 @@ func main() {
 					Name:  "remove",
 					Usage: "Remove 4G",
 				},
+				cli.BoolFlag{
+					Name:  "status",
+					Usage: "prints the json state of the modem from ModemManager",
+				},
+				cli.BoolFlag{
+					Name:  "mm-connect",
+					Usage: "Connects the modem with ModemManager, this is run by systemd",
+				},
+				cli.BoolFlag{
+					Name:  "mm-disconnect",
+					Usage: "Disconnects the modem with ModemManager, this is run by systemd",
+				},
+				cli.BoolFlag{
+					Name:  "list-apns",
+					Usage: "prints the json APN profiles of the operator of the SIM",
+				},
+				cli.BoolFlag{
+					Name:  "wwan-connect",
+					Usage: "Starts the qmi or mbim data session, this is run by systemd",
+				},
+				cli.BoolFlag{
+					Name:  "wwan-disconnect",
+					Usage: "Stops the qmi or mbim data session, this is run by systemd",
+				},
 			},
 			Action: FourgCMD,
 		},
@@ -123,6 +152,30 @@ func main() {
 					Name:  "remove",
 					Usage: "Remove 3G",
 				},
+				cli.BoolFlag{
+					Name:  "status",
+					Usage: "prints the json state of the modem from ModemManager",
+				},
+				cli.BoolFlag{
+					Name:  "mm-connect",
+					Usage: "Connects the modem with ModemManager, this is run by systemd",
+				},
+				cli.BoolFlag{
+					Name:  "mm-disconnect",
+					Usage: "Disconnects the modem with ModemManager, this is run by systemd",
+				},
+				cli.BoolFlag{
+					Name:  "list-apns",
+					Usage: "prints the json APN profiles of the operator of the SIM",
+				},
+				cli.BoolFlag{
+					Name:  "sim-status",
+					Usage: "prints the json PIN/PUK state of the SIM and the attempts left",
+				},
+				cli.BoolFlag{
+					Name:  "unlock-sim",
+					Usage: "Enters the PIN when the SIM asks for it, this is run by systemd",
+				},
 			},
 			Action: ThreegCMD,
 		},
@@ -158,6 +211,22 @@ func main() {
 					Name:  "remove",
 					Usage: "Remove wifi",
 				},
+				cli.StringFlag{
+					Name:  "add-network",
+					Usage: "The path to the json file of a network to add, or stdin",
+				},
+				cli.StringFlag{
+					Name:  "remove-network",
+					Usage: "The ssid of the network to remove",
+				},
+				cli.BoolFlag{
+					Name:  "scan",
+					Usage: "prints a json array of wifi networks in range",
+				},
+				cli.BoolFlag{
+					Name:  "status",
+					Usage: "prints the live wifi client status as json",
+				},
 			},
 			Action: WifiClientCMD,
 		},
@@ -193,9 +262,113 @@ func main() {
 					Name:  "remove",
 					Usage: "Remove access point",
 				},
+				cli.StringFlag{
+					Name:  "allow",
+					Usage: "The mac address of a station to allow",
+				},
+				cli.StringFlag{
+					Name:  "deny",
+					Usage: "The mac address of a station to remove from the allowed list",
+				},
+				cli.BoolFlag{
+					Name:  "list-macs",
+					Usage: "prints a json list of allowed stations",
+				},
+				cli.BoolFlag{
+					Name:  "clients",
+					Usage: "prints a json array of connected stations",
+				},
+				cli.StringFlag{
+					Name:  "import",
+					Usage: "The path to an existing create_ap configuration file to import",
+				},
+				cli.BoolFlag{
+					Name:  "apply-schedule",
+					Usage: "Starts or stops the access point according to its schedule",
+				},
+				cli.BoolFlag{
+					Name:  "limits",
+					Usage: "prints the json bandwidth limits of the clients",
+				},
 			},
 			Action: ApCMD,
 		},
+		{
+			Name:  "portal",
+			Usage: "runs the captive portal of the access point",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "serve",
+					Usage: "Serves the landing page, this is run by systemd",
+				},
+				cli.StringFlag{
+					Name:  "accept",
+					Usage: "The mac address of a client to let through",
+				},
+				cli.StringFlag{
+					Name:  "revoke",
+					Usage: "The mac address of a client to send back to the landing page",
+				},
+				cli.BoolFlag{
+					Name:  "list",
+					Usage: "prints a json list of accepted clients",
+				},
+			},
+			Action: PortalCMD,
+		},
+		{
+			Name:  "modem",
+			Usage: "finds the modems on the serial ports",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "probe",
+					Usage: "prints json of the modems answering AT commands",
+				},
+			},
+			Action: ModemCMD,
+		},
+		{
+			Name:  "usage",
+			Usage: "prints the json data used by the 3g and 4g modems",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "sample",
+					Usage: "Counts the data used and applies the caps, this is run by systemd",
+				},
+				cli.BoolFlag{
+					Name:  "enable",
+					Usage: "Starts counting the data used every minute",
+				},
+				cli.BoolFlag{
+					Name:  "disable",
+					Usage: "Stops counting the data used",
+				},
+			},
+			Action: UsageCMD,
+		},
+		{
+			Name:  "watchdog",
+			Usage: "restarts or replaces the 3g and 4g modems that lose connectivity",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config",
+					Usage: "The path to the json watchdog configuration file",
+				},
+				cli.BoolFlag{
+					Name:  "enable",
+					Usage: "Starts the watchdog service",
+				},
+				cli.BoolFlag{
+					Name:  "disable",
+					Usage: "Stops the watchdog service",
+				},
+				cli.BoolFlag{
+					Name:  "run",
+					Usage: "Runs the watchdog, this is run by systemd",
+				},
+			},
+			Action: WatchdogCMD,
+		},
 		{
 			Name:    "voice-channel",
 			Aliases: []string{"v"},
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+)
+
+// testStateDir points FCONF_CONFIGDIR at a temporary directory that is removed
+// when the test ends.
+func testStateDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("FCONF_CONFIGDIR", dir)
+	return dir
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/urfave/cli"
+)
+
+// Modem backends.
+const (
+	BackendWvdial       = "wvdial"
+	BackendModemManager = "modemmanager"
+)
+
+const mmService = "fconf-mm-%s.service"
+
+// connectAttempts and connectWait are how often and how far apart the
+// connection services try to connect, a modem may take minutes to register
+// after boot. They are variables so tests do not wait.
+var (
+	connectAttempts = 10
+	connectWait     = 30 * time.Second
+)
+
+// mmcli runs ModemManager command line client and returns its output. It is a
+// variable so tests can replace ModemManager with canned output.
+var mmcli = func(args ...string) (string, error) {
+	o, err := exec.Command("mmcli", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("fconf: mmcli %s %v", strings.Join(args, " "), err)
+	}
+	return string(o), nil
+}
+
+//MMModem is a modem managed by ModemManager.
+type MMModem struct {
+	Path         string    `json:"path"`
+	Manufacturer string    `json:"manufacturer"`
+	Model        string    `json:"model"`
+	IMEI         string    `json:"imei"`
+	IMSI         string    `json:"imsi"`
+	State        string    `json:"state"`
+	Operator     string    `json:"operator"`
+	OperatorCode string    `json:"operator_code"`
+	Registration string    `json:"registration"`
+	Signal       int       `json:"signal"`
+	AccessTech   []string  `json:"access_technologies"`
+	Bearer       *MMBearer `json:"bearer,omitempty"`
+	sim          string
+	bearers      []string
+	ports        []string
+	pinRetries   int
+}
+
+//MMBearer is a data connection of a modem.
+type MMBearer struct {
+	Path      string   `json:"path"`
+	Connected bool     `json:"connected"`
+	Interface string   `json:"interface"`
+	APN       string   `json:"apn"`
+	Method    string   `json:"method"`
+	Address   string   `json:"address,omitempty"`
+	Prefix    int      `json:"prefix,omitempty"`
+	Gateway   string   `json:"gateway,omitempty"`
+	DNS       []string `json:"dns,omitempty"`
+}
+
+// parseMMCLI parses the key value output of mmcli -K. Each line looks like
+//
+//	modem.3gpp.imei                : 861234567890123
+//
+// mmcli prints -- for empty values.
+func parseMMCLI(src string) map[string]string {
+	kv := make(map[string]string)
+	s := bufio.NewScanner(strings.NewReader(src))
+	for s.Scan() {
+		p := strings.SplitN(s.Text(), ":", 2)
+		if len(p) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(p[1])
+		if v == "--" {
+			v = ""
+		}
+		kv[strings.TrimSpace(p[0])] = v
+	}
+	return kv
+}
+
+// mmValues returns the values of the list key in the output of mmcli -K.
+func mmValues(kv map[string]string, key string) []string {
+	n, _ := strconv.Atoi(kv[key+".length"])
+	var result []string
+	for i := 1; i <= n; i++ {
+		result = append(result, kv[fmt.Sprintf("%s.value[%d]", key, i)])
+	}
+	return result
+}
+
+func parseMMModem(kv map[string]string) *MMModem {
+	m := &MMModem{
+		Path:         kv["modem.dbus-path"],
+		Manufacturer: kv["modem.generic.manufacturer"],
+		Model:        kv["modem.generic.model"],
+		IMEI:         kv["modem.3gpp.imei"],
+		State:        kv["modem.generic.state"],
+		Operator:     kv["modem.3gpp.operator-name"],
+		OperatorCode: kv["modem.3gpp.operator-code"],
+		Registration: kv["modem.3gpp.registration-state"],
+		AccessTech:   mmValues(kv, "modem.generic.access-technologies"),
+		sim:          kv["modem.generic.sim"],
+		bearers:      mmValues(kv, "modem.generic.bearers"),
+	}
+	for _, v := range mmValues(kv, "modem.generic.ports") {
+		// each value looks like ttyUSB2 (at)
+		if f := strings.Fields(v); len(f) > 0 {
+			m.ports = append(m.ports, f[0])
+		}
+	}
+	if m.IMEI == "" {
+		m.IMEI = kv["modem.generic.equipment-identifier"]
+	}
+	m.Signal, _ = strconv.Atoi(kv["modem.generic.signal-quality.value"])
+	m.pinRetries = -1
+	for _, v := range mmValues(kv, "modem.generic.unlock-retries") {
+		// each value looks like sim-pin (3)
+		var n int
+		if _, err := fmt.Sscanf(v, "sim-pin (%d)", &n); err == nil {
+			m.pinRetries = n
+		}
+	}
+	return m
+}
+
+func parseMMBearer(kv map[string]string) *MMBearer {
+	b := &MMBearer{
+		Path:      kv["bearer.dbus-path"],
+		Connected: kv["bearer.status.connected"] == "yes",
+		Interface: kv["bearer.status.interface"],
+		APN:       kv["bearer.properties.apn"],
+		Method:    kv["bearer.ipv4-config.method"],
+		Address:   kv["bearer.ipv4-config.address"],
+		Gateway:   kv["bearer.ipv4-config.gateway"],
+		DNS:       mmValues(kv, "bearer.ipv4-config.dns"),
+	}
+	b.Prefix, _ = strconv.Atoi(kv["bearer.ipv4-config.prefix"])
+	return b
+}
+
+// mmModem returns the modem with the dbus path p, with the IMSI of its SIM
+// and its connected bearer.
+func mmModem(p string) (*MMModem, error) {
+	o, err := mmcli("-K", "-m", p)
+	if err != nil {
+		return nil, err
+	}
+	m := parseMMModem(parseMMCLI(o))
+	if m.sim != "" {
+		o, err = mmcli("-K", "-i", m.sim)
+		if err != nil {
+			return nil, err
+		}
+		m.IMSI = parseMMCLI(o)["sim.properties.imsi"]
+	}
+	for _, b := range m.bearers {
+		o, err = mmcli("-K", "-b", b)
+		if err != nil {
+			return nil, err
+		}
+		bearer := parseMMBearer(parseMMCLI(o))
+		if bearer.Connected || m.Bearer == nil {
+			m.Bearer = bearer
+		}
+	}
+	return m, nil
+}
+
+//MMModems returns all modems managed by ModemManager.
+func MMModems() ([]*MMModem, error) {
+	o, err := mmcli("-K", "-L")
+	if err != nil {
+		return nil, err
+	}
+	var result []*MMModem
+	for _, p := range mmValues(parseMMCLI(o), "modem-list") {
+		m, err := mmModem(p)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// mmPorts returns the names of the ports ModemManager uses, none when it is
+// not running.
+func mmPorts() map[string]bool {
+	ports := make(map[string]bool)
+	ms, err := MMModems()
+	if err != nil {
+		return ports
+	}
+	for _, m := range ms {
+		for _, p := range m.ports {
+			ports[p] = true
+		}
+	}
+	return ports
+}
+
+//FindMMModem returns the modem with the IMEI imei or with a SIM whose IMSI is
+//imsi. Empty values are ignored.
+func FindMMModem(imei, imsi string) (*MMModem, error) {
+	if imei == "" && imsi == "" {
+		return nil, errors.New("fconf: missing imei or imsi of the modem")
+	}
+	modems, err := MMModems()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range modems {
+		if (imei != "" && m.IMEI == imei) || (imsi != "" && m.IMSI == imsi) {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("fconf: no modem with imei %q or imsi %q", imei, imsi)
+}
+
+// mmQuote quotes values of mmcli simple connect settings that have a comma
+// or a quote.
+func mmQuote(s string) string {
+	if !strings.ContainsAny(s, ",'\" ") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", "\\'", -1) + "'"
+}
+
+//Connect connects a data bearer with apn and the credentials, and returns the
+//modem with the bearer.
+func (m *MMModem) Connect(apn, username, password string) (*MMModem, error) {
+	if apn == "" {
+		return nil, errors.New("fconf: missing apn")
+	}
+	s := []string{"apn=" + mmQuote(apn)}
+	if username != "" {
+		s = append(s, "user="+mmQuote(username))
+	}
+	if password != "" {
+		s = append(s, "password="+mmQuote(password))
+	}
+	_, err := mmcli("-m", m.Path, "--simple-connect="+strings.Join(s, ","))
+	if err != nil {
+		return nil, err
+	}
+	return mmModem(m.Path)
+}
+
+//Disconnect disconnects all data bearers of the modem.
+func (m *MMModem) Disconnect() error {
+	_, err := mmcli("-m", m.Path, "--simple-disconnect")
+	return err
+}
+
+// applyBearer configures the network interface of a connected bearer. With
+// the dhcp method the address is left to systemd-networkd.
+func applyBearer(b *MMBearer, metric int, defaultRoute bool) error {
+	if b == nil || !b.Connected {
+		return errors.New("fconf: bearer is not connected")
+	}
+	var cmds [][]string
+	switch b.Method {
+	case "static":
+		cmds = append(cmds,
+			[]string{"link", "set", b.Interface, "up"},
+			[]string{"addr", "flush", "dev", b.Interface},
+			[]string{"addr", "add", fmt.Sprintf("%s/%d", b.Address, b.Prefix), "dev", b.Interface},
+		)
+		if defaultRoute && b.Gateway != "" {
+			cmds = append(cmds, []string{"route", "replace", "default", "via", b.Gateway,
+				"dev", b.Interface, "metric", fmt.Sprint(metric)})
+		}
+	case "dhcp":
+		cmds = append(cmds, []string{"link", "set", b.Interface, "up"})
+	case "ppp":
+		return errors.New("fconf: the modem needs ppp, use the wvdial backend")
+	default:
+		return fmt.Errorf("fconf: unknown bearer ip method %s", b.Method)
+	}
+	for _, c := range cmds {
+		o, err := exec.Command("ip", c...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("fconf: ip %s %v %s", strings.Join(c, " "), err, o)
+		}
+	}
+	return nil
+}
+
+// mmTarget is the modem and the data settings of a 3g or 4g configuration
+// using the ModemManager backend. Key is the IMSI or the interface the PIN is
+// saved under.
+type mmTarget struct {
+	Key          string
+	IMEI         string
+	IMSI         string
+	APN          string
+	Username     string
+	Password     string
+	Metric       int
+	DefaultRoute bool
+	PIN          string
+}
+
+// mmConnect finds the modem of t, connects it and configures its interface.
+func mmConnect(t *mmTarget) (*MMModem, error) {
+	err := mmUnlock(t)
+	if err != nil {
+		return nil, err
+	}
+	m, err := FindMMModem(t.IMEI, t.IMSI)
+	if err != nil {
+		return nil, err
+	}
+	m, err = m.Connect(t.APN, t.Username, t.Password)
+	if err != nil {
+		return nil, err
+	}
+	err = applyBearer(m.Bearer, t.Metric, t.DefaultRoute)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("connected %s to %s on %s\n", m.IMEI, t.APN, m.Bearer.Interface)
+	return m, nil
+}
+
+// mmUnlock enters the PIN of t when its modem is locked. A locked SIM does not
+// tell its IMSI, so the modem is found by its IMEI. Like UnlockSIM it does not
+// use the last attempt, and a PIN the SIM rejected is not entered again.
+func mmUnlock(t *mmTarget) error {
+	if t.PIN == "" || t.IMEI == "" {
+		return nil
+	}
+	ms, err := MMModems()
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		if m.IMEI != t.IMEI || m.State != "locked" {
+			continue
+		}
+		if m.sim == "" {
+			return fmt.Errorf("fconf: modem %s has no SIM", m.IMEI)
+		}
+		if pinRejected(t.Key) {
+			return errPINRejected
+		}
+		if m.pinRetries == 1 {
+			return errors.New("fconf: one PIN attempt left, enter the PIN by hand")
+		}
+		_, err = mmcli("-i", m.sim, "--pin="+t.PIN)
+		if err != nil {
+			log.Printf("mm: %v", err)
+			rerr := rejectPIN(t.Key)
+			if rerr != nil {
+				return rerr
+			}
+			return errPINRejected
+		}
+		return nil
+	}
+	return nil
+}
+
+// mmDisconnect finds the modem of t and disconnects it.
+func mmDisconnect(t *mmTarget) error {
+	m, err := FindMMModem(t.IMEI, t.IMSI)
+	if err != nil {
+		return err
+	}
+	return m.Disconnect()
+}
+
+// mmUnit is the systemd service that connects a modem through ModemManager
+// on boot. cmd is the fconf command of the modem and key its interface or
+// IMSI.
+type mmUnit struct {
+	cmd string
+	key string
+	bin string
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (m mmUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	if m.key == "" {
+		return nil, errors.New("fconf: missing modem")
+	}
+	run := m.bin + " --interface " + m.key + " " + m.cmd
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf ModemManager connection of "+m.key),
+		unit.NewUnitOption("Unit", "Requires", "ModemManager.service"),
+		unit.NewUnitOption("Unit", "After", "ModemManager.service"),
+		unit.NewUnitOption("Service", "Type", "oneshot"),
+		unit.NewUnitOption("Service", "RemainAfterExit", "yes"),
+		unit.NewUnitOption("Service", "ExecStart", run+" --mm-connect"),
+		unit.NewUnitOption("Service", "ExecStop", run+" --mm-disconnect"),
+		unit.NewUnitOption("Install", "WantedBy", "multi-user.target"),
+	}, nil
+}
+
+// writeMMService writes the ModemManager connection service of the modem key
+// configured with the fconf command cmd.
+func writeMMService(cmd, key string) error {
+	err := CreateSystemdFile(mmUnit{cmd, key, fconfBin()},
+		filepath.Join(systemdBase, fmt.Sprintf(mmService, key)), 0644)
+	if err != nil {
+		return err
+	}
+	return reloadSystemd()
+}
+
+// removeMMService stops and removes the ModemManager connection service of
+// the modem key.
+func removeMMService(key string) error {
+	return removeUnit(fmt.Sprintf(mmService, key))
+}
+
+// removeUnit stops, disables and removes the systemd service.
+func removeUnit(service string) error {
+	err := stopService(service)
+	if err != nil {
+		return err
+	}
+	err = disableService(service)
+	if err != nil {
+		return err
+	}
+	err = removeFile(filepath.Join(systemdBase, service))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// retryConnect calls connect until it succeeds or connectAttempts run out. A
+// rejected PIN is not retried.
+// The connection services retry here, systemd before v244 does not restart
+// oneshot services.
+func retryConnect(name string, connect func() error) error {
+	var err error
+	for n := 1; n <= connectAttempts; n++ {
+		err = connect()
+		if err == nil || err == errPINRejected {
+			return err
+		}
+		log.Printf("%s: connecting failed %d/%d %v", name, n, connectAttempts, err)
+		if n < connectAttempts {
+			time.Sleep(connectWait)
+		}
+	}
+	return err
+}
+
+// mmCMD connects or disconnects the modem t, or prints its state as json.
+func mmCMD(ctx *cli.Context, t *mmTarget) error {
+	switch {
+	case ctx.IsSet(mmConnectFlag):
+		return retryConnect("mm", func() error {
+			_, err := mmConnect(t)
+			return err
+		})
+	case ctx.IsSet(mmDisconnectFlag):
+		return mmDisconnect(t)
+	}
+	m, err := FindMMModem(t.IMEI, t.IMSI)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
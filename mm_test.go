@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeMMCLI answers mmcli queries with the output in fixture/mmcli and records
+// the other commands.
+func fakeMMCLI(calls *[]string) func() {
+	orig := mmcli
+	mmcli = func(args ...string) (string, error) {
+		if len(args) == 3 && args[0] == "-K" {
+			name := map[string]string{
+				"-m": "modem", "-i": "sim", "-b": "bearer",
+			}[args[1]] + path.Base(args[2])
+			b, err := ioutil.ReadFile("fixture/mmcli/" + name + ".txt")
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		}
+		if len(args) == 2 && args[1] == "-L" {
+			b, err := ioutil.ReadFile("fixture/mmcli/list.txt")
+			return string(b), err
+		}
+		*calls = append(*calls, strings.Join(args, " "))
+		return "", nil
+	}
+	return func() {
+		mmcli = orig
+	}
+}
+
+func TestFindMMModem(t *testing.T) {
+	var calls []string
+	defer fakeMMCLI(&calls)()
+
+	m, err := FindMMModem("", "639030000000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.IMEI != "861234567890999" || m.Operator != "Airtel" || m.Signal != 67 {
+		t.Errorf("unexpected modem %+v", m)
+	}
+	e := &MMBearer{
+		Path:      "/org/freedesktop/ModemManager1/Bearer/3",
+		Connected: true,
+		Interface: "wwan0",
+		APN:       "internet",
+		Method:    "static",
+		Address:   "100.64.12.7",
+		Prefix:    30,
+		Gateway:   "100.64.12.5",
+		DNS:       []string{"41.90.0.1", "41.90.0.2"},
+	}
+	if !reflect.DeepEqual(m.Bearer, e) {
+		t.Errorf("expected %+v got %+v", e, m.Bearer)
+	}
+
+	m, err = FindMMModem("861234567890123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.IMSI != "639020000000001" || m.Bearer != nil {
+		t.Errorf("unexpected modem %+v", m)
+	}
+	if !reflect.DeepEqual(m.AccessTech, []string{"lte"}) {
+		t.Errorf("expected lte got %v", m.AccessTech)
+	}
+
+	_, err = FindMMModem("1", "2")
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	_, err = m.Connect("safaricom", "saf", "data, 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := fmt.Sprintf("-m %s --simple-connect=apn=safaricom,user=saf,password='data, 1'", m.Path)
+	if len(calls) != 1 || calls[0] != exp {
+		t.Errorf("expected %s got %v", exp, calls)
+	}
+}
+
+func TestRetryConnect(t *testing.T) {
+	attempts, wait := connectAttempts, connectWait
+	defer func() {
+		connectAttempts, connectWait = attempts, wait
+	}()
+	connectAttempts, connectWait = 3, 0
+	n := 0
+	err := retryConnect("test", func() error {
+		n++
+		if n < 2 {
+			return fmt.Errorf("attempt %d", n)
+		}
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Errorf("expected success on the second attempt got %v after %d", err, n)
+	}
+	n = 0
+	err = retryConnect("test", func() error {
+		n++
+		return fmt.Errorf("attempt %d", n)
+	})
+	if err == nil || n != 3 {
+		t.Errorf("expected an error after 3 attempts got %v after %d", err, n)
+	}
+}
+
+func TestMMUnlock(t *testing.T) {
+	testStateDir(t)
+
+	retries := "3"
+	var pins []string
+	orig := mmcli
+	defer func() {
+		mmcli = orig
+	}()
+	mmcli = func(args ...string) (string, error) {
+		switch {
+		case args[len(args)-1] == "-L":
+			return "modem-list.length : 1\nmodem-list.value[1] : /org/freedesktop/ModemManager1/Modem/0\n", nil
+		case args[0] == "-K":
+			return `modem.generic.state                   : locked
+modem.generic.sim                     : /org/freedesktop/ModemManager1/SIM/0
+modem.generic.unlock-retries.length   : 2
+modem.generic.unlock-retries.value[1] : sim-pin (` + retries + `)
+modem.generic.unlock-retries.value[2] : sim-puk (10)
+modem.3gpp.imei                       : 861234567890123
+`, nil
+		}
+		pins = append(pins, strings.Join(args, " "))
+		return "", errors.New("fconf: mmcli wrong pin")
+	}
+
+	tg := &mmTarget{Key: "639020000000001", IMEI: "861234567890123", PIN: "1234"}
+	err := mmUnlock(tg)
+	if err != errPINRejected {
+		t.Fatalf("expected %v got %v", errPINRejected, err)
+	}
+	err = mmUnlock(tg)
+	if err != errPINRejected || len(pins) != 1 {
+		t.Errorf("expected the rejected PIN to be entered once got %v %v", err, pins)
+	}
+
+	err = savePIN(tg.Key, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retries = "1"
+	pins = nil
+	err = mmUnlock(tg)
+	if err == nil || len(pins) != 0 {
+		t.Errorf("expected the last attempt to be kept got %v %v", err, pins)
+	}
+}
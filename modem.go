@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/coreos/go-systemd/unit"
@@ -21,6 +22,46 @@ type FourGState struct {
 
 type FourG struct {
 	Network
+
+	// Backend is empty when the modem connects on its own, or modemmanager
+	// to connect it with ModemManager using the settings below.
+	Backend  string `json:"backend,omitempty"`
+	IMEI     string `json:"imei,omitempty"`
+	IMSI     string `json:"imsi,omitempty"`
+	APN      string `json:"apn,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// APNProfile picks the profile of the operator used to fill in missing
+	// APN settings, when the operator has several.
+	APNProfile string `json:"apn_profile,omitempty"`
+
+	// Mode is ndis, qmi or mbim, defaults to ndis. With qmi and mbim fconf
+	// starts the data session on Device, the control device of the modem
+	// which defaults to /dev/cdc-wdm0. IPType is ipv4, ipv6 or ipv4v6 and
+	// Auth none, pap, chap or both.
+	Mode   string `json:"mode,omitempty"`
+	Device string `json:"device,omitempty"`
+	IPType string `json:"ip_type,omitempty"`
+	Auth   string `json:"auth,omitempty"`
+
+	// PIN unlocks the SIM before connecting. It is kept in a file only root
+	// can read and never in the state file.
+	PIN string `json:"pin,omitempty"`
+
+	// Cap disables the modem when it used its data, see fconf usage.
+	Cap *DataCap `json:"cap,omitempty"`
+}
+
+func (f *FourG) mmTarget() *mmTarget {
+	// systemd-networkd sets up the address and routes of the interface.
+	return &mmTarget{
+		IMEI:     f.IMEI,
+		IMSI:     f.IMSI,
+		APN:      f.APN,
+		Username: f.Username,
+		Password: f.Password,
+	}
 }
 
 //ToSystemdUnit implement UnitFile interface
@@ -52,6 +93,28 @@ func fourGState(i string) (*FourGState, error) {
 	return f, nil
 }
 
+// fourGStates returns the state of all configured 4g modems, ordered by
+// interface.
+func fourGStates() ([]*FourGState, error) {
+	m, err := filepath.Glob(filepath.Join(stateDir(),
+		fmt.Sprintf(defaultFougGConfig, "*")))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(m)
+	var result []*FourGState
+	prefix := strings.Split(defaultFougGConfig, "%s")
+	for _, v := range m {
+		i := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(v), prefix[0]), prefix[1])
+		s, err := fourGState(i)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
 func FourgCMD(ctx *cli.Context) error {
 	if ctx.IsSet(enableFlag) {
 		return EnableFourg(ctx)
@@ -65,6 +128,38 @@ func FourgCMD(ctx *cli.Context) error {
 	if ctx.IsSet(configFlag) {
 		return configFourgCMD(ctx)
 	}
+	if ctx.IsSet(wwanConnectFlag) || ctx.IsSet(wwanDisconnectFlag) {
+		i := getInterface(ctx)
+		if i == "" {
+			return errors.New("missing interface, you must specify interface")
+		}
+		e, err := fourGState(i)
+		if err != nil {
+			return err
+		}
+		return wwanCMD(ctx, i, e.Configg)
+	}
+	if ctx.IsSet(mmConnectFlag) || ctx.IsSet(mmDisconnectFlag) ||
+		ctx.IsSet(statusFlag) || ctx.IsSet(listAPNsFlag) {
+		i := getInterface(ctx)
+		if i == "" {
+			return errors.New("missing interface, you must specify interface")
+		}
+		e, err := fourGState(i)
+		if err != nil {
+			return err
+		}
+		if ctx.IsSet(listAPNsFlag) {
+			return listAPNs(e.Configg.IMSI)
+		}
+		t := e.Configg.mmTarget()
+		t.Key = i
+		t.PIN, err = readPIN(i)
+		if err != nil {
+			return err
+		}
+		return mmCMD(ctx, t)
+	}
 	return nil
 }
 
@@ -95,6 +190,10 @@ func RemoveFourg(ctx *cli.Context) error {
 			return err
 		}
 	}
+	err = removePIN(i)
+	if err != nil {
+		return err
+	}
 	// removestate file
 	stateFile := filepath.Join(stateDir(),
 		fmt.Sprintf(defaultFougGConfig, i))
@@ -144,6 +243,21 @@ func EnableFourg(ctx *cli.Context) error {
 			return err
 		}
 	}
+	if e.Configg.Mode == ModeQMI || e.Configg.Mode == ModeMBIM {
+		err = writeWWANService(i)
+		if err != nil {
+			return err
+		}
+		service := fmt.Sprintf(wwanService, i)
+		err = restartService(service)
+		if err != nil {
+			return fmt.Errorf("ERROR: restarting systemd %v ", err)
+		}
+		err = enableService(service)
+		if err != nil {
+			return fmt.Errorf("ERROR: enabling systemd %v ", err)
+		}
+	}
 	_, err = exec.Command("ip", "link", "set", "up", e.Configg.Interface).Output()
 	if err != nil {
 		return fmt.Errorf("ERROR: runnin ip link set up %s %v",
@@ -154,6 +268,21 @@ func EnableFourg(ctx *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("ERROR: restarting systemd %v ", err)
 	}
+	if e.Configg.Backend == BackendModemManager {
+		err = writeMMService("4g-ndis", i)
+		if err != nil {
+			return err
+		}
+		service := fmt.Sprintf(mmService, i)
+		err = restartService(service)
+		if err != nil {
+			return fmt.Errorf("ERROR: restarting systemd %v ", err)
+		}
+		err = enableService(service)
+		if err != nil {
+			return fmt.Errorf("ERROR: enabling systemd %v ", err)
+		}
+	}
 	e.Enabled = true
 	data, err := json.Marshal(e)
 	if err != nil {
@@ -175,6 +304,18 @@ func DisableFourg(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if e.Configg.Backend == BackendModemManager {
+		err = removeMMService(i)
+		if err != nil {
+			return err
+		}
+	}
+	if e.Configg.Mode == ModeQMI || e.Configg.Mode == ModeMBIM {
+		err = removeUnit(fmt.Sprintf(wwanService, i))
+		if err != nil {
+			return err
+		}
+	}
 	_, err = exec.Command("ip", "addr", "flush", "dev", e.Configg.Interface).Output()
 	if err != nil {
 		return fmt.Errorf("ERROR: running ip addr flush dev %s %v",
@@ -231,6 +372,28 @@ func configFourgCMD(ctx *cli.Context) error {
 	if e.Interface == "" {
 		e.Interface = "eth1"
 	}
+	if e.Backend == BackendModemManager && e.IMEI == "" && e.IMSI == "" {
+		return errors.New("fconf: modemmanager backend needs the imei or imsi of the modem")
+	}
+	if e.PIN != "" && e.Mode != ModeQMI && e.Mode != ModeMBIM &&
+		(e.Backend != BackendModemManager || e.IMEI == "") {
+		return errors.New("fconf: pin needs qmi or mbim mode, or the modemmanager backend and the imei of the modem")
+	}
+	if e.Backend == BackendModemManager || e.Mode == ModeQMI || e.Mode == ModeMBIM {
+		// ndis modems dial on their own and take no APN from fconf.
+		err = e.FillAPN()
+		if err != nil {
+			return err
+		}
+	}
+	err = e.validateMode()
+	if err != nil {
+		return err
+	}
+	err = e.Cap.validate()
+	if err != nil {
+		return err
+	}
 	err = checkDir(base)
 	if err != nil {
 		return err
@@ -250,6 +413,13 @@ func configFourgCMD(ctx *cli.Context) error {
 		state.Enabled = ms.Enabled
 	}
 	ctx.GlobalSet("interface", e.Interface)
+	if e.PIN != "" {
+		err = savePIN(e.Interface, e.PIN)
+		if err != nil {
+			return err
+		}
+		e.PIN = ""
+	}
 	b, _ = json.Marshal(state)
 	return keepState(
 		fmt.Sprintf(defaultFougGConfig, e.Interface), b)
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/urfave/cli"
+)
+
+const (
+	defaultPortalPort    = 2050
+	defaultPortalDNSPort = 2053
+	portalService        = "fconf-portal-%s.service"
+	portalChain          = "fconf_portal_%s"
+
+	// portalTTL is the ttl of the hijacked DNS answers. It is short so that
+	// clients resolve the real addresses soon after they are accepted.
+	portalTTL = 10
+)
+
+//CaptivePortal redirects new clients of the access point to a landing page.
+//Until a client accepts the page, all its DNS queries resolve to the gateway
+//and its HTTP requests are answered by fconf.
+type CaptivePortal struct {
+	// Page is the path to the html landing page. It must have a form that
+	// posts to /accept. A default page is served when it is empty.
+	Page    string `json:"page,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	DNSPort int    `json:"dns_port,omitempty"`
+}
+
+func (c *CaptivePortal) port() int {
+	if c.Port == 0 {
+		return defaultPortalPort
+	}
+	return c.Port
+}
+
+func (c *CaptivePortal) dnsPort() int {
+	if c.DNSPort == 0 {
+		return defaultPortalDNSPort
+	}
+	return c.DNSPort
+}
+
+//PortalState is the list of clients that accepted the landing page.
+type PortalState struct {
+	Accepted []string `json:"accepted"`
+}
+
+func portalState(i string) (*PortalState, error) {
+	b, err := ioutil.ReadFile(filepath.Join(stateDir(),
+		fmt.Sprintf(defaultPortalState, i)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &PortalState{}, nil
+		}
+		return nil, err
+	}
+	s := &PortalState{}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func savePortalState(i string, s *PortalState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return keepState(fmt.Sprintf(defaultPortalState, i), data)
+}
+
+//PortalRules returns the iptables arguments that setup the captive portal and
+//the ones that remove it. Clients are sent to the portal chain, which
+//redirects DNS and HTTP to fconf and drops forwarded traffic. Accepted
+//clients return early from the chain, see PortalAcceptRules. Forwarded
+//traffic is dropped in the mangle table, which create_ap leaves alone, so the
+//ACCEPT rules it inserts in the filter FORWARD chain come too late.
+func (a *AccessPoint) PortalRules() (setup [][]string, teardown [][]string, err error) {
+	if a.Portal == nil {
+		return nil, nil, errors.New("fconf: captive portal is not configured")
+	}
+	ai := a.apIface()
+	chain := fmt.Sprintf(portalChain, a.WifiIface)
+	port := fmt.Sprint(a.Portal.port())
+	dnsPort := fmt.Sprint(a.Portal.dnsPort())
+	setup = [][]string{
+		{"-t", "nat", "-N", chain},
+		{"-t", "nat", "-A", chain, "-p", "udp", "--dport", "53", "-j", "DNAT", "--to-destination", a.Gateway + ":" + dnsPort},
+		{"-t", "nat", "-A", chain, "-p", "tcp", "--dport", "80", "-j", "DNAT", "--to-destination", a.Gateway + ":" + port},
+		{"-t", "nat", "-I", "PREROUTING", "-i", ai, "-j", chain},
+		{"-t", "mangle", "-N", chain},
+		{"-t", "mangle", "-A", chain, "-j", "DROP"},
+		{"-t", "mangle", "-I", "FORWARD", "-i", ai, "-j", chain},
+		{"-I", "INPUT", "-i", ai, "-p", "tcp", "--dport", port, "-j", "ACCEPT"},
+		{"-I", "INPUT", "-i", ai, "-p", "udp", "--dport", dnsPort, "-j", "ACCEPT"},
+	}
+	teardown = [][]string{
+		{"-D", "INPUT", "-i", ai, "-p", "udp", "--dport", dnsPort, "-j", "ACCEPT"},
+		{"-D", "INPUT", "-i", ai, "-p", "tcp", "--dport", port, "-j", "ACCEPT"},
+		{"-t", "mangle", "-D", "FORWARD", "-i", ai, "-j", chain},
+		{"-t", "mangle", "-F", chain},
+		{"-t", "mangle", "-X", chain},
+		{"-t", "nat", "-D", "PREROUTING", "-i", ai, "-j", chain},
+		{"-t", "nat", "-F", chain},
+		{"-t", "nat", "-X", chain},
+	}
+	return setup, teardown, nil
+}
+
+//PortalAcceptRules returns the iptables arguments that let the client with
+//address mac skip the captive portal on interface i. Replace -I with -D to
+//delete them.
+func PortalAcceptRules(i, mac string) [][]string {
+	chain := fmt.Sprintf(portalChain, i)
+	return [][]string{
+		{"-t", "nat", "-I", chain, "-m", "mac", "--mac-source", mac, "-j", "RETURN"},
+		{"-t", "mangle", "-I", chain, "-m", "mac", "--mac-source", mac, "-j", "RETURN"},
+	}
+}
+
+func iptables(args ...string) error {
+	o, err := exec.Command("iptables", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fconf: iptables %s %v %s", strings.Join(args, " "), err, o)
+	}
+	return nil
+}
+
+// portalRunning returns true if the firewall rules of the captive portal on
+// interface i are setup. It is a variable so tests do not run iptables.
+var portalRunning = func(i string) bool {
+	return exec.Command("iptables", "-t", "nat", "-n", "-L",
+		fmt.Sprintf(portalChain, i)).Run() == nil
+}
+
+// deleteRules turns rules inserted with -I into rules that delete them.
+func deleteRules(rules [][]string) [][]string {
+	var result [][]string
+	for _, r := range rules {
+		d := make([]string, len(r))
+		for k, v := range r {
+			if v == "-I" {
+				v = "-D"
+			}
+			d[k] = v
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// lockPortal serializes changes to the accepted list of the portal on
+// interface i. The portal accepts clients from concurrent requests while fconf
+// portal --accept and --revoke run in their own processes.
+func lockPortal(i string) (*os.File, error) {
+	return lockState(fmt.Sprintf(defaultPortalState, i))
+}
+
+//PortalAccept lets the client with address mac through the captive portal on
+//interface i.
+func PortalAccept(i, mac string) error {
+	m, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	l, err := lockPortal(i)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	s, err := portalState(i)
+	if err != nil {
+		return err
+	}
+	for _, v := range s.Accepted {
+		if v == m.String() {
+			return nil
+		}
+	}
+	if portalRunning(i) {
+		for _, r := range PortalAcceptRules(i, m.String()) {
+			err = iptables(r...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	s.Accepted = append(s.Accepted, m.String())
+	return savePortalState(i, s)
+}
+
+//PortalRevoke sends the client with address mac back to the captive portal
+//on interface i.
+func PortalRevoke(i, mac string) error {
+	m, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	l, err := lockPortal(i)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	s, err := portalState(i)
+	if err != nil {
+		return err
+	}
+	var macs []string
+	found := false
+	for _, v := range s.Accepted {
+		if v == m.String() {
+			found = true
+			continue
+		}
+		macs = append(macs, v)
+	}
+	if !found {
+		return fmt.Errorf("fconf: %s is not in the accepted list", m)
+	}
+	for _, r := range deleteRules(PortalAcceptRules(i, m.String())) {
+		// the rules are gone when the portal is not running.
+		_ = iptables(r...)
+	}
+	s.Accepted = macs
+	return savePortalState(i, s)
+}
+
+// arpMAC returns the hardware address of ip from the kernel arp table, which
+// looks like
+//
+//	IP address       HW type     Flags       HW address            Mask     Device
+//	192.168.12.45    0x1         0x2         aa:bb:cc:dd:ee:ff     *        wlan0
+func arpMAC(r io.Reader, ip string) (string, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 4 || f[0] != ip {
+			continue
+		}
+		if f[3] == "00:00:00:00:00:00" {
+			break
+		}
+		return strings.ToLower(f[3]), nil
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("fconf: no hardware address for %s", ip)
+}
+
+// dnsAnswer returns the reply to the DNS query req, all A queries resolve to
+// ip. Other queries are answered without records.
+func dnsAnswer(req []byte, ip net.IP) ([]byte, error) {
+	if len(req) < 12 {
+		return nil, errors.New("fconf: short dns query")
+	}
+	if binary.BigEndian.Uint16(req[4:]) != 1 {
+		return nil, errors.New("fconf: dns query must have one question")
+	}
+	// skip the name of the question.
+	n := 12
+	for {
+		if n >= len(req) {
+			return nil, errors.New("fconf: bad dns query")
+		}
+		l := int(req[n])
+		if l == 0 {
+			n++
+			break
+		}
+		if l&0xc0 != 0 {
+			return nil, errors.New("fconf: bad dns query")
+		}
+		n += l + 1
+	}
+	if n+4 > len(req) {
+		return nil, errors.New("fconf: bad dns query")
+	}
+	qtype := binary.BigEndian.Uint16(req[n:])
+	qclass := binary.BigEndian.Uint16(req[n+2:])
+	n += 4
+	res := make([]byte, n, n+16)
+	copy(res, req[:n])
+	// response, recursion available, copy the recursion desired bit.
+	res[2] = 0x80 | req[2]&0x01
+	res[3] = 0x80
+	// no authority or additional records.
+	binary.BigEndian.PutUint16(res[8:], 0)
+	binary.BigEndian.PutUint16(res[10:], 0)
+	ip4 := ip.To4()
+	if ip4 == nil || qclass != 1 || (qtype != 1 && qtype != 255) {
+		binary.BigEndian.PutUint16(res[6:], 0)
+		return res, nil
+	}
+	binary.BigEndian.PutUint16(res[6:], 1)
+	a := make([]byte, 16)
+	binary.BigEndian.PutUint16(a[0:], 0xc00c)
+	binary.BigEndian.PutUint16(a[2:], 1)
+	binary.BigEndian.PutUint16(a[4:], 1)
+	binary.BigEndian.PutUint32(a[6:], portalTTL)
+	binary.BigEndian.PutUint16(a[10:], 4)
+	copy(a[12:], ip4)
+	return append(res, a...), nil
+}
+
+// servePortalDNS answers DNS queries on conn until it is closed.
+func servePortalDNS(conn net.PacketConn, ip net.IP) error {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		res, err := dnsAnswer(buf[:n], ip)
+		if err != nil {
+			continue
+		}
+		_, _ = conn.WriteTo(res, addr)
+	}
+}
+
+var defaultPortalPage = template.Must(template.New("portal").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+</head>
+<body>
+<h1>Welcome to {{.}}</h1>
+<form method="post" action="/accept">
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+const portalAcceptedPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Connected</title>
+</head>
+<body>
+<h1>You are connected</h1>
+</body>
+</html>
+`
+
+// portalHandler serves the landing page. Requests for other hosts, which
+// reach it through the DNS and HTTP redirection, are redirected to the
+// gateway.
+type portalHandler struct {
+	gateway string
+	page    []byte
+	accept  func(ip string) error
+}
+
+func (p *portalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host != p.gateway {
+		http.Redirect(w, r, "http://"+p.gateway+"/", http.StatusFound)
+		return
+	}
+	if r.URL.Path == "/accept" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = p.accept(ip)
+		if err != nil {
+			log.Printf("portal: accepting %s %v", ip, err)
+			http.Error(w, "can not accept the client", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(w, portalAcceptedPage)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(p.page)
+}
+
+// portalPage returns the landing page of the access point.
+func portalPage(a *AccessPoint) ([]byte, error) {
+	if a.Portal.Page != "" {
+		return ioutil.ReadFile(a.Portal.Page)
+	}
+	var buf strings.Builder
+	err := defaultPortalPage.Execute(&buf, a.SSID)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
+
+// setupPortal adds the firewall rules of the portal on interface i and lets
+// the accepted clients through. It holds the portal lock so that a client
+// accepted meanwhile is not added twice.
+func setupPortal(i string, setup [][]string) error {
+	l, err := lockPortal(i)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	for _, r := range setup {
+		err = iptables(r...)
+		if err != nil {
+			return err
+		}
+	}
+	s, err := portalState(i)
+	if err != nil {
+		return err
+	}
+	for _, mac := range s.Accepted {
+		for _, r := range PortalAcceptRules(i, mac) {
+			err = iptables(r...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// servePortal sets up the firewall rules of the captive portal and serves the
+// landing page until the process is stopped.
+func servePortal(a *AccessPoint) error {
+	i := a.WifiIface
+	page, err := portalPage(a)
+	if err != nil {
+		return err
+	}
+	setup, teardown, err := a.PortalRules()
+	if err != nil {
+		return err
+	}
+	// remove the leftovers of a portal that did not stop cleanly.
+	for _, r := range teardown {
+		_ = iptables(r...)
+	}
+	defer func() {
+		for _, r := range teardown {
+			_ = iptables(r...)
+		}
+	}()
+	err = setupPortal(i, setup)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf("%s:%d", a.Gateway, a.Portal.dnsPort()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	l, err := net.Listen("tcp4", fmt.Sprintf("%s:%d", a.Gateway, a.Portal.port()))
+	if err != nil {
+		return err
+	}
+	h := &portalHandler{
+		gateway: a.Gateway,
+		page:    page,
+		accept: func(ip string) error {
+			f, err := os.Open("/proc/net/arp")
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			mac, err := arpMAC(f, ip)
+			if err != nil {
+				return err
+			}
+			log.Printf("portal: accepted %s %s", mac, ip)
+			return PortalAccept(i, mac)
+		},
+	}
+	// the clients of an open access point are not trusted, do not let them
+	// hold connections.
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       30 * time.Second,
+	}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- servePortalDNS(conn, net.ParseIP(a.Gateway))
+	}()
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sig:
+		_ = srv.Close()
+		return nil
+	case err = <-errs:
+		_ = srv.Close()
+		return err
+	}
+}
+
+// portalUnit is the systemd service that runs the captive portal of the
+// access point. It is bound to the service of the access point so the portal
+// only runs with it.
+type portalUnit struct {
+	*AccessPoint
+	bin string
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (p portalUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	i := p.WifiIface
+	if i == "" {
+		return nil, errors.New("fconf: missing wifi interface")
+	}
+	ap := apServices(p.State())[0]
+	if !strings.HasSuffix(ap, ".service") {
+		ap += ".service"
+	}
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf captive portal on "+i),
+		unit.NewUnitOption("Unit", "BindsTo", ap),
+		unit.NewUnitOption("Unit", "After", ap),
+		unit.NewUnitOption("Service", "ExecStart",
+			p.bin+" --interface "+i+" portal --serve"),
+		unit.NewUnitOption("Service", "Restart", "on-failure"),
+		unit.NewUnitOption("Install", "WantedBy", ap),
+	}, nil
+}
+
+// fconfBin returns the path of the running fconf binary, used to run fconf
+// from systemd services.
+func fconfBin() string {
+	p, err := os.Executable()
+	if err != nil {
+		return "/usr/local/bin/fconf"
+	}
+	return p
+}
+
+// writePortal writes the systemd service of the captive portal, or removes
+// it when the portal is turned off.
+func writePortal(a *AccessPoint) error {
+	name := filepath.Join(systemdBase, fmt.Sprintf(portalService, a.WifiIface))
+	if a.Portal == nil {
+		err := removeFile(name)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	err := CreateSystemdFile(portalUnit{a, fconfBin()}, name, 0644)
+	if err != nil {
+		return err
+	}
+	return reloadSystemd()
+}
+
+//PortalCMD runs the captive portal of the access point and manages the
+//clients that accepted the landing page.
+func PortalCMD(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	switch {
+	case ctx.IsSet(acceptFlag):
+		return PortalAccept(i, ctx.String(acceptFlag))
+	case ctx.IsSet(revokeFlag):
+		return PortalRevoke(i, ctx.String(revokeFlag))
+	case ctx.IsSet(listFlag):
+		s, err := portalState(i)
+		if err != nil {
+			return err
+		}
+		if s.Accepted == nil {
+			s.Accepted = []string{}
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	case ctx.IsSet(serveFlag):
+		state, err := accessPointState(i)
+		if err != nil {
+			return err
+		}
+		ap := DefaultAccesPoint()
+		ap.Update(state.Configg)
+		return servePortal(ap)
+	}
+	return nil
+}
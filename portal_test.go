@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// dnsQuery returns a query for name with type qtype.
+func dnsQuery(name string, qtype uint16) []byte {
+	q := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, l := range strings.Split(name, ".") {
+		q = append(q, byte(len(l)))
+		q = append(q, l...)
+	}
+	q = append(q, 0, byte(qtype>>8), byte(qtype), 0, 1)
+	return q
+}
+
+func TestDNSAnswer(t *testing.T) {
+	ip := net.ParseIP("192.168.12.1")
+	q := dnsQuery("connectivitycheck.gstatic.com", 1)
+	res, err := dnsAnswer(q, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0] != 0x12 || res[1] != 0x34 {
+		t.Error("expected the id of the query")
+	}
+	if res[2]&0x80 == 0 || res[2]&0x01 == 0 {
+		t.Errorf("expected a response with recursion desired got %x", res[2])
+	}
+	if n := binary.BigEndian.Uint16(res[6:]); n != 1 {
+		t.Fatalf("expected 1 answer got %d", n)
+	}
+	if !bytes.Equal(res[len(res)-4:], ip.To4()) {
+		t.Errorf("expected %s got %v", ip, res[len(res)-4:])
+	}
+
+	// AAAA queries get no answer so clients fall back to IPv4.
+	res, err = dnsAnswer(dnsQuery("example.com", 28), ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := binary.BigEndian.Uint16(res[6:]); n != 0 {
+		t.Errorf("expected no answer got %d", n)
+	}
+
+	_, err = dnsAnswer(q[:20], ip)
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestArpMAC(t *testing.T) {
+	src := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.12.45    0x1         0x2         AA:BB:CC:DD:EE:FF     *        wlan0
+192.168.12.46    0x1         0x0         00:00:00:00:00:00     *        wlan0
+`
+	mac, err := arpMAC(strings.NewReader(src), "192.168.12.45")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("expected aa:bb:cc:dd:ee:ff got %s", mac)
+	}
+	for _, ip := range []string{"192.168.12.46", "192.168.12.47"} {
+		_, err = arpMAC(strings.NewReader(src), ip)
+		if err == nil {
+			t.Errorf("%s: expected an error", ip)
+		}
+	}
+}
+
+func TestPortalHandler(t *testing.T) {
+	var accepted []string
+	h := &portalHandler{
+		gateway: "192.168.12.1",
+		page:    []byte("landing"),
+		accept: func(ip string) error {
+			accepted = append(accepted, ip)
+			return nil
+		},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	c := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/generate_204", nil)
+	req.Host = "connectivitycheck.gstatic.com"
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "http://192.168.12.1/" {
+		t.Errorf("expected a redirect to the gateway got %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	req, _ = http.NewRequest("GET", srv.URL+"/", nil)
+	req.Host = "192.168.12.1:" + u.Port()
+	res, err = c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(b) != "landing" {
+		t.Errorf("expected the landing page got %s", b)
+	}
+
+	req, _ = http.NewRequest("POST", srv.URL+"/accept", nil)
+	req.Host = "192.168.12.1"
+	res, err = c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 got %d", res.StatusCode)
+	}
+	if len(accepted) != 1 || accepted[0] != "127.0.0.1" {
+		t.Errorf("expected 127.0.0.1 to be accepted got %v", accepted)
+	}
+}
+
+func TestPortalAcceptConcurrent(t *testing.T) {
+	testStateDir(t)
+	running := portalRunning
+	defer func() {
+		portalRunning = running
+	}()
+	portalRunning = func(string) bool { return false }
+
+	var wg sync.WaitGroup
+	for k := 0; k < 20; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, byte(k)}
+			if err := PortalAccept("fconf-test0", mac.String()); err != nil {
+				t.Error(err)
+			}
+		}(k)
+	}
+	wg.Wait()
+	s, err := portalState("fconf-test0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Accepted) != 20 {
+		t.Errorf("expected 20 accepted clients got %d", len(s.Accepted))
+	}
+}
+
+func TestPortalUnit(t *testing.T) {
+	a := DefaultAccesPoint()
+	a.Update(&AccessPointConfig{
+		Interface:      "wlan0",
+		SSID:           "voxbox",
+		Passphrase:     "voxbox99",
+		ShareInterfaec: "eth0",
+		Portal:         &CaptivePortal{},
+	})
+	u, err := portalUnit{a, "/usr/local/bin/fconf"}.ToSystemdUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _ := ioutil.ReadAll(unit.Serialize(u))
+	exp, err := ioutil.ReadFile("fixture/fconf-portal.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, o) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), string(o))
+	}
+	s := apServices(a.State())
+	if len(s) != 2 || s[1] != "fconf-portal-wlan0.service" {
+		t.Errorf("expected the portal service got %v", s)
+	}
+}
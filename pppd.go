@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// BackendPPPD dials 3g modems with pppd and chat, for systems without
+// wvdial.
+const BackendPPPD = "pppd"
+
+const (
+	pppdService = "pppd@%s"
+	pppdUnit    = "pppd@.service"
+	pppdPeer    = "fconf-%s"
+	pppdPeers   = "/etc/ppp/peers"
+	chatScripts = "/etc/chatscripts"
+
+	defaultBaud = 115200
+)
+
+var peerTpl = template.Must(template.New("peer").Funcs(template.FuncMap{
+	"quote": pppdQuote,
+}).Parse(`# written by fconf, pppd peer of the 3g modem {{.IMSI}}
+{{.Device}}
+{{.Speed}}
+lock
+noauth
+noipdefault
+unit {{.Unit}}
+ipparam {{.IMSI}}
+connect "/usr/sbin/chat -v -f {{.Chat}}"
+{{- if .Username}}
+user {{quote .Username}}
+{{- end}}
+{{- if .Password}}
+password {{quote .Password}}
+{{- end}}
+{{- if .DefaultGateway}}
+defaultroute
+defaultroute-metric {{.Metric}}
+{{- if .ReplaceDefaultRoute}}
+replacedefaultroute
+{{- end}}
+{{- else}}
+nodefaultroute
+{{- end}}
+{{- if .UsePeerDNS}}
+usepeerdns
+{{- end}}
+{{- if .Persist}}
+persist
+{{- end}}
+{{- if .MaxFail}}
+maxfail {{.MaxFail}}
+{{- end}}
+{{- if .IdleSeconds}}
+idle {{.IdleSeconds}}
+{{- end}}
+{{- if .LCPEchoInterval}}
+lcp-echo-interval {{.LCPEchoInterval}}
+{{- end}}
+{{- if .LCPEchoFailure}}
+lcp-echo-failure {{.LCPEchoFailure}}
+{{- end}}
+`))
+
+var chatTpl = template.Must(template.New("chat").Parse(`# written by fconf, chat script of the 3g modem {{.IMSI}}
+ABORT "BUSY"
+ABORT "NO CARRIER"
+ABORT "NO DIALTONE"
+ABORT "ERROR"
+ABORT "NO ANSWER"
+TIMEOUT 30
+"" ATZ
+OK "ATQ0 V1 E1 S0=0 &C1 &D2"
+OK 'AT+CGDCONT=1,"IP","{{.APN}}"'
+{{- range .Init}}
+OK '{{.}}'
+{{- end}}
+OK ATD{{.Dial}}
+CONNECT ""
+`))
+
+// pppdQuote quotes s for a pppd options file, which takes backslash escapes
+// in double quoted words.
+func pppdQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// peerName returns the pppd peer file of the modem.
+func (c *ThreeG) peerName() string {
+	return filepath.Join(pppdPeers, fmt.Sprintf(pppdPeer, c.IMSI))
+}
+
+// chatName returns the chat script of the modem.
+func (c *ThreeG) chatName() string {
+	return filepath.Join(chatScripts, fmt.Sprintf(pppdPeer, c.IMSI))
+}
+
+//WritePeer writes the pppd peer file of the modem. pppd adds the default
+//route with the metric of the modem, unlike wvdial where the ip-up script
+//does it.
+func (c *ThreeG) WritePeer(dst io.Writer) (int64, error) {
+	ctx := struct {
+		*ThreeG
+		Device     string
+		Speed      int
+		Unit       int
+		Metric     int
+		Chat       string
+		UsePeerDNS bool
+		Persist    bool
+	}{
+		ThreeG:     c,
+		Device:     c.device(),
+		Speed:      c.Baud,
+		Unit:       c.unit(),
+		Metric:     c.metric(),
+		Chat:       c.chatName(),
+		UsePeerDNS: c.AutoDNS == nil || *c.AutoDNS,
+		Persist:    c.AutoReconnect == nil || *c.AutoReconnect,
+	}
+	if ctx.Speed == 0 {
+		ctx.Speed = defaultBaud
+	}
+	var buf bytes.Buffer
+	err := peerTpl.Execute(&buf, ctx)
+	if err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(dst)
+}
+
+//WriteChat writes the chat script that sets the APN and dials the modem.
+func (c *ThreeG) WriteChat(dst io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	err := chatTpl.Execute(&buf, c)
+	if err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(dst)
+}
+
+// pppdTemplate is the systemd template unit that runs the pppd peer of a
+// modem, the instance name is the IMSI. Like wvdialTemplate it enters the PIN
+// first, and dials even if that fails.
+type pppdTemplate struct {
+	bin string
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (p pppdTemplate) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "pppd 3g modem %i"),
+		unit.NewUnitOption("Unit", "After", "network.target"),
+		unit.NewUnitOption("Service", "ExecStartPre",
+			"-"+p.bin+" --interface %i 3g-ras --"+unlockSIMFlag),
+		unit.NewUnitOption("Service", "ExecStart",
+			"/usr/sbin/pppd call "+fmt.Sprintf(pppdPeer, "%i")+" nodetach"),
+		unit.NewUnitOption("Service", "Restart", "always"),
+		unit.NewUnitOption("Service", "RestartSec", "10"),
+		unit.NewUnitOption("Install", "WantedBy", "multi-user.target"),
+	}, nil
+}
+
+// writePPPDPeers writes the peer files and chat scripts of the modems using
+// the pppd backend, and the pppd@ template unit.
+func writePPPDPeers(modems []*ThreeG) error {
+	if len(modems) == 0 {
+		return nil
+	}
+	for _, d := range []string{pppdPeers, chatScripts} {
+		err := checkDir(d)
+		if err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for _, m := range modems {
+		buf.Reset()
+		_, err := m.WritePeer(&buf)
+		if err != nil {
+			return err
+		}
+		// the peer file has the password of the modem.
+		err = ioutil.WriteFile(m.peerName(), buf.Bytes(), 0600)
+		if err != nil {
+			return err
+		}
+		buf.Reset()
+		_, err = m.WriteChat(&buf)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(m.chatName(), buf.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("written pppd peer to %s\n", m.peerName())
+	}
+	return CreateSystemdFile(pppdTemplate{fconfBin()},
+		filepath.Join(systemdBase, pppdUnit), 0644)
+}
+
+// removePPPDPeer removes the peer file and chat script of the modem.
+func removePPPDPeer(c *ThreeG) error {
+	for _, f := range []string{c.peerName(), c.chatName()} {
+		err := removeFile(f)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
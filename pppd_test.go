@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWritePeer(t *testing.T) {
+	u, off := 1, false
+	m := &ThreeG{
+		IMSI:                "621300000000003",
+		APN:                 "web.gprs.mtnnigeria.net",
+		Dial:                "*99#",
+		Username:            "web",
+		Password:            `pa"ss`,
+		DefaultGateway:      true,
+		PPPUnit:             &u,
+		Backend:             BackendPPPD,
+		Init:                []string{"AT+CFUN=1"},
+		AutoDNS:             &off,
+		ReplaceDefaultRoute: true,
+		MaxFail:             5,
+		LCPEchoInterval:     30,
+		LCPEchoFailure:      4,
+	}
+	err := m.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := []struct {
+		fixture string
+		write   func(*bytes.Buffer) (int64, error)
+	}{
+		{"fixture/fconf-pppd-peer", func(b *bytes.Buffer) (int64, error) { return m.WritePeer(b) }},
+		{"fixture/fconf-pppd-chat", func(b *bytes.Buffer) (int64, error) { return m.WriteChat(b) }},
+	}
+	for _, v := range sample {
+		var buf bytes.Buffer
+		_, err = v.write(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, err := ioutil.ReadFile(v.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(exp, buf.Bytes()) {
+			t.Errorf("expected \n %s \n Got \n %s", string(exp), buf.String())
+		}
+	}
+
+	m.APN = "it's"
+	if m.validate() == nil {
+		t.Error("expected an error for a quote in the apn")
+	}
+	if m.service() != "pppd@621300000000003" {
+		t.Errorf("unexpected service %s", m.service())
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// probeTimeout is short because most serial ports of a modem are not AT
+// ports and never answer.
+const probeTimeout = 2 * time.Second
+
+var (
+	// devDir and lockDir are variables so tests can use a fake /dev.
+	devDir  = "/dev"
+	lockDir = "/var/lock"
+
+	serialPatterns = []string{"ttyUSB*", "ttyACM*", "*.imsi"}
+)
+
+//ModemInfo is what a modem tells about itself over its AT port.
+type ModemInfo struct {
+	Ports        []string `json:"ports"`
+	Links        []string `json:"links,omitempty"`
+	IMEI         string   `json:"imei"`
+	IMSI         string   `json:"imsi"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	Operator     string   `json:"operator"`
+	Registration string   `json:"registration"`
+
+	// Signal is the signal quality in percent like ModemManager reports it,
+	// -1 when unknown.
+	Signal int `json:"signal"`
+}
+
+// serialPort is a serial device and the symlinks to it.
+type serialPort struct {
+	path  string
+	links []string
+}
+
+// serialPorts returns the serial ports in devDir that may be modem ports,
+// with the /dev/<imsi>.imsi links pointing at them.
+func serialPorts() ([]*serialPort, error) {
+	ports := make(map[string]*serialPort)
+	for _, p := range serialPatterns {
+		m, err := filepath.Glob(filepath.Join(devDir, p))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range m {
+			real, err := filepath.EvalSymlinks(name)
+			if err != nil {
+				// dangling link of an unplugged modem.
+				continue
+			}
+			s, ok := ports[real]
+			if !ok {
+				s = &serialPort{path: real}
+				ports[real] = s
+			}
+			if real != name {
+				s.links = append(s.links, name)
+			}
+		}
+	}
+	var paths []string
+	for k := range ports {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+	var result []*serialPort
+	for _, k := range paths {
+		sort.Strings(ports[k].links)
+		result = append(result, ports[k])
+	}
+	return result, nil
+}
+
+// portLocked returns true if pppd or wvdial hold the lock file of the port,
+// talking to it would break their connection. They lock the name they were
+// given, which is often a /dev/<imsi>.imsi link.
+func portLocked(s *serialPort) bool {
+	for _, name := range append([]string{s.path}, s.links...) {
+		_, err := os.Stat(filepath.Join(lockDir, "LCK.."+filepath.Base(name)))
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// atString returns the value of reply without the prefix and quotes, for
+// replies like +CGSN: "861234567890123".
+func atString(lines []string, prefix string) string {
+	for _, l := range lines {
+		if prefix == "" || strings.HasPrefix(l, prefix) {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(l, prefix)), `"`)
+		}
+	}
+	return ""
+}
+
+// registrationStates are the names of the +CREG states, the same as
+// ModemManager uses.
+var registrationStates = []string{"idle", "home", "searching", "denied", "unknown", "roaming"}
+
+//Probe asks the modem on the port who it is. Commands the modem does not
+//support leave their fields empty.
+func (p *ATPort) Probe() (*ModemInfo, error) {
+	// a port that does not answer AT is not an AT port.
+	_, err := p.Command("AT")
+	if err != nil {
+		return nil, err
+	}
+	m := &ModemInfo{Signal: -1}
+	if lines, err := p.Command("ATI"); err == nil {
+		parseATI(m, lines)
+	}
+	if lines, err := p.Command("AT+CGSN"); err == nil {
+		m.IMEI = atString(lines, "+CGSN:")
+		if m.IMEI == "" {
+			m.IMEI = atString(lines, "")
+		}
+	}
+	if lines, err := p.Command("AT+CIMI"); err == nil {
+		m.IMSI = atString(lines, "")
+	}
+	if lines, err := p.Command("AT+CSQ"); err == nil {
+		// +CSQ: <rssi>,<ber>, rssi goes from 0 to 31 and 99 is unknown.
+		f := strings.Split(atString(lines, "+CSQ:"), ",")
+		if n, err := strconv.Atoi(strings.TrimSpace(f[0])); err == nil && n <= 31 {
+			m.Signal = n * 100 / 31
+		}
+	}
+	if lines, err := p.Command("AT+COPS?"); err == nil {
+		// +COPS: <mode>,<format>,"<operator>",<act>
+		f := strings.Split(atString(lines, "+COPS:"), ",")
+		if len(f) >= 3 {
+			m.Operator = strings.Trim(f[2], `"`)
+		}
+	}
+	if lines, err := p.Command("AT+CREG?"); err == nil {
+		// +CREG: <n>,<stat> with more fields when n is 2.
+		f := strings.Split(atString(lines, "+CREG:"), ",")
+		if len(f) >= 2 {
+			n, err := strconv.Atoi(strings.TrimSpace(f[1]))
+			if err == nil && n >= 0 && n < len(registrationStates) {
+				m.Registration = registrationStates[n]
+			}
+		}
+	}
+	return m, nil
+}
+
+// parseATI reads the manufacturer and model from the reply to ATI. Huawei
+// modems name the fields, others reply with the manufacturer and model on the
+// first two lines.
+func parseATI(m *ModemInfo, lines []string) {
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "Manufacturer:"):
+			m.Manufacturer = strings.TrimSpace(strings.TrimPrefix(l, "Manufacturer:"))
+		case strings.HasPrefix(l, "Model:"):
+			m.Model = strings.TrimSpace(strings.TrimPrefix(l, "Model:"))
+		}
+	}
+	if m.Manufacturer == "" && len(lines) >= 2 && !strings.Contains(lines[0], ":") {
+		m.Manufacturer = lines[0]
+		m.Model = lines[1]
+	}
+}
+
+// ProbeModems probes all serial ports with open and returns the modems
+// answering, a modem with several AT ports is reported once with all its
+// ports. Ports that are locked or that ModemManager uses are left alone.
+func ProbeModems(open func(string) (*ATPort, error)) ([]*ModemInfo, error) {
+	ports, err := serialPorts()
+	if err != nil {
+		return nil, err
+	}
+	mm := mmPorts()
+	var result []*ModemInfo
+	byIMEI := make(map[string]*ModemInfo)
+	for _, s := range ports {
+		if portLocked(s) || mm[filepath.Base(s.path)] {
+			continue
+		}
+		p, err := open(s.path)
+		if err != nil {
+			continue
+		}
+		p.Timeout = probeTimeout
+		m, err := p.Probe()
+		_ = p.Close()
+		if err != nil {
+			continue
+		}
+		if o, ok := byIMEI[m.IMEI]; ok && m.IMEI != "" {
+			o.Ports = append(o.Ports, s.path)
+			o.Links = append(o.Links, s.links...)
+			continue
+		}
+		m.Ports = []string{s.path}
+		m.Links = s.links
+		byIMEI[m.IMEI] = m
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+//ModemCMD probes the modems on the serial ports.
+func ModemCMD(ctx *cli.Context) error {
+	if ctx.IsSet(probeFlag) {
+		m, err := ProbeModems(OpenATPort)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var huaweiReplies = map[string]string{
+	"AT":       "OK",
+	"ATI":      "Manufacturer: huawei\nModel: E173\nRevision: 11.126.85.00.209\nIMEI: 861234567890123\n+GCAP: +CGSM,+DS,+ES\n\nOK",
+	"AT+CGSN":  "861234567890123\n\nOK",
+	"AT+CIMI":  "639030000000002\n\nOK",
+	"AT+CSQ":   "+CSQ: 17,99\n\nOK",
+	"AT+COPS?": "+COPS: 0,0,\"Airtel\",2\n\nOK",
+	"AT+CREG?": "+CREG: 0,5\n\nOK",
+}
+
+func TestProbe(t *testing.T) {
+	m, err := NewATPort(&fakeModem{replies: huaweiReplies}).Probe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &ModemInfo{
+		IMEI:         "861234567890123",
+		IMSI:         "639030000000002",
+		Manufacturer: "huawei",
+		Model:        "E173",
+		Operator:     "Airtel",
+		Registration: "roaming",
+		Signal:       54,
+	}
+	if !reflect.DeepEqual(m, e) {
+		t.Errorf("expected %+v got %+v", e, m)
+	}
+
+	// quectel modems reply with +CGSN and name nothing in ATI, no SIM.
+	m, err = NewATPort(&fakeModem{replies: map[string]string{
+		"AT":       "OK",
+		"ATI":      "Quectel\nEC25\nRevision: EC25EFAR06A06M4G\n\nOK",
+		"AT+CGSN":  "+CGSN: \"867000000000001\"\n\nOK",
+		"AT+CIMI":  "+CME ERROR: SIM not inserted",
+		"AT+CSQ":   "+CSQ: 99,99\n\nOK",
+		"AT+COPS?": "+COPS: 0\n\nOK",
+		"AT+CREG?": "+CREG: 0,2\n\nOK",
+	}}).Probe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = &ModemInfo{
+		IMEI:         "867000000000001",
+		Manufacturer: "Quectel",
+		Model:        "EC25",
+		Registration: "searching",
+		Signal:       -1,
+	}
+	if !reflect.DeepEqual(m, e) {
+		t.Errorf("expected %+v got %+v", e, m)
+	}
+}
+
+func TestProbeModems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dev := filepath.Join(dir, "dev")
+	lock := filepath.Join(dir, "lock")
+	for _, d := range []string{dev, lock} {
+		if err = os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []string{"ttyUSB0", "ttyUSB1", "ttyUSB2", "ttyUSB3", "ttyUSB4", "ttyUSB5", "sda"} {
+		if err = ioutil.WriteFile(filepath.Join(dev, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"639030000000002.imsi": "ttyUSB2",
+		"639030000000009.imsi": "ttyUSB9",
+		"639030000000005.imsi": "ttyUSB5",
+	}
+	for k, v := range links {
+		if err = os.Symlink(v, filepath.Join(dev, k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// pppd locks ttyUSB0, wvdial ttyUSB5 through its link and ModemManager
+	// uses ttyUSB4.
+	for _, n := range []string{"LCK..ttyUSB0", "LCK..639030000000005.imsi"} {
+		if err = ioutil.WriteFile(filepath.Join(lock, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var calls []string
+	defer fakeMMCLI(&calls)()
+	origDev, origLock := devDir, lockDir
+	devDir, lockDir = dev, lock
+	defer func() {
+		devDir, lockDir = origDev, origLock
+	}()
+
+	var opened []string
+	m, err := ProbeModems(func(name string) (*ATPort, error) {
+		opened = append(opened, filepath.Base(name))
+		switch filepath.Base(name) {
+		case "ttyUSB1":
+			// the data port does not speak AT.
+			return NewATPort(&fakeModem{}), nil
+		case "ttyUSB2", "ttyUSB3":
+			return NewATPort(&fakeModem{replies: huaweiReplies}), nil
+		}
+		return nil, errors.New("unexpected port")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := []string{"ttyUSB1", "ttyUSB2", "ttyUSB3"}; !reflect.DeepEqual(opened, e) {
+		t.Errorf("expected to open %v got %v", e, opened)
+	}
+	if len(m) != 1 {
+		t.Fatalf("expected one modem got %d", len(m))
+	}
+	ports := []string{filepath.Join(dev, "ttyUSB2"), filepath.Join(dev, "ttyUSB3")}
+	if !reflect.DeepEqual(m[0].Ports, ports) {
+		t.Errorf("expected ports %v got %v", ports, m[0].Ports)
+	}
+	l := []string{filepath.Join(dev, "639030000000002.imsi")}
+	if !reflect.DeepEqual(m[0].Links, l) {
+		t.Errorf("expected links %v got %v", l, m[0].Links)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/urfave/cli"
+)
+
+const (
+	apScheduleService = "fconf-ap-schedule-%s.service"
+	apScheduleTimer   = "fconf-ap-schedule-%s.timer"
+)
+
+// weekDays are the day names used by systemd calendar events, in the order of
+// time.Weekday.
+var weekDays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+//APWindow is a weekly window during which the access point is on. Days are
+//three letter day names, weekdays or weekend. An empty list means every day.
+//Start and Stop are HH:MM times, a window stops on the next day when Stop is
+//before Start.
+type APWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	Stop  string   `json:"stop"`
+}
+
+// parse returns the days of w indexed by time.Weekday and the start and stop
+// times in minutes since midnight.
+func (w *APWindow) parse() (days [7]bool, start, stop int, err error) {
+	if len(w.Days) == 0 {
+		for k := range days {
+			days[k] = true
+		}
+	}
+	for _, d := range w.Days {
+		switch strings.ToLower(d) {
+		case "weekdays":
+			for k := time.Monday; k <= time.Friday; k++ {
+				days[k] = true
+			}
+			continue
+		case "weekend":
+			days[time.Saturday] = true
+			days[time.Sunday] = true
+			continue
+		}
+		found := false
+		for k, v := range weekDays {
+			if strings.EqualFold(d, v) {
+				days[k] = true
+				found = true
+			}
+		}
+		if !found {
+			return days, 0, 0, fmt.Errorf("unknown day %s", d)
+		}
+	}
+	start, err = parseClock(w.Start)
+	if err != nil {
+		return days, 0, 0, err
+	}
+	stop, err = parseClock(w.Stop)
+	if err != nil {
+		return days, 0, 0, err
+	}
+	if start == stop {
+		return days, 0, 0, errors.New("start and stop can not be the same")
+	}
+	return days, start, stop, nil
+}
+
+// parseClock returns the minutes since midnight of a HH:MM time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time %s, must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+//Active returns true if t is inside the window.
+func (w *APWindow) Active(t time.Time) bool {
+	days, start, stop, err := w.parse()
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	if start < stop {
+		return days[today] && now >= start && now < stop
+	}
+	// the window goes past midnight.
+	yesterday := (today + 6) % 7
+	return (days[today] && now >= start) || (days[yesterday] && now < stop)
+}
+
+// calendar returns the systemd calendar events of the start and stop of the
+// window.
+func (w *APWindow) calendar() (start, stop string, err error) {
+	days, s, e, err := w.parse()
+	if err != nil {
+		return "", "", err
+	}
+	stopDays := days
+	if e < s {
+		// the window stops on the next day.
+		for k := range days {
+			stopDays[(k+1)%7] = days[k]
+		}
+	}
+	event := func(d [7]bool, m int) string {
+		var names []string
+		// systemd weeks start on monday.
+		for _, k := range []int{1, 2, 3, 4, 5, 6, 0} {
+			if d[k] {
+				names = append(names, weekDays[k])
+			}
+		}
+		c := fmt.Sprintf("*-*-* %02d:%02d:00", m/60, m%60)
+		if len(names) == 7 {
+			return c
+		}
+		return strings.Join(names, ",") + " " + c
+	}
+	return event(days, s), event(stopDays, e), nil
+}
+
+//ScheduleActive returns true if the access point should be on at t. It is
+//always on when there is no schedule.
+func ScheduleActive(schedule []*APWindow, t time.Time) bool {
+	if len(schedule) == 0 {
+		return true
+	}
+	for _, w := range schedule {
+		if w.Active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleService is the oneshot systemd service that starts or stops the
+// access point depending on the time it is run.
+type scheduleService struct {
+	*AccessPoint
+	bin string
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (s scheduleService) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	i := s.WifiIface
+	if i == "" {
+		return nil, errors.New("fconf: missing wifi interface")
+	}
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf access point schedule on "+i),
+		unit.NewUnitOption("Service", "Type", "oneshot"),
+		unit.NewUnitOption("Service", "ExecStart",
+			s.bin+" --interface "+i+" access-point --apply-schedule"),
+	}, nil
+}
+
+// scheduleTimer runs scheduleService at the start and stop of every window,
+// and after boot so that the access point is off when the box starts outside
+// a window.
+type scheduleTimer struct {
+	*AccessPoint
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (s scheduleTimer) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	i := s.WifiIface
+	if i == "" {
+		return nil, errors.New("fconf: missing wifi interface")
+	}
+	u := []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf access point schedule on "+i),
+	}
+	for _, w := range s.Schedule {
+		start, stop, err := w.calendar()
+		if err != nil {
+			return nil, err
+		}
+		u = append(u,
+			unit.NewUnitOption("Timer", "OnCalendar", start),
+			unit.NewUnitOption("Timer", "OnCalendar", stop),
+		)
+	}
+	u = append(u,
+		unit.NewUnitOption("Timer", "OnBootSec", "1min"),
+		unit.NewUnitOption("Timer", "Unit", fmt.Sprintf(apScheduleService, i)),
+		unit.NewUnitOption("Install", "WantedBy", "timers.target"),
+	)
+	return u, nil
+}
+
+// writeSchedule writes the timer and service of the access point schedule,
+// or removes them when there is no schedule.
+func writeSchedule(a *AccessPoint) error {
+	i := a.WifiIface
+	service := filepath.Join(systemdBase, fmt.Sprintf(apScheduleService, i))
+	timer := filepath.Join(systemdBase, fmt.Sprintf(apScheduleTimer, i))
+	if len(a.Schedule) == 0 {
+		if !fileExists(timer) {
+			return nil
+		}
+		// the loaded timer keeps firing until it is stopped.
+		err := removeUnit(fmt.Sprintf(apScheduleTimer, i))
+		if err != nil {
+			return err
+		}
+		err = removeFile(service)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return reloadSystemd()
+	}
+	err := CreateSystemdFile(scheduleService{a, fconfBin()}, service, 0644)
+	if err != nil {
+		return err
+	}
+	err = CreateSystemdFile(scheduleTimer{a}, timer, 0644)
+	if err != nil {
+		return err
+	}
+	return reloadSystemd()
+}
+
+// applySchedule starts or stops the access point services depending on the
+// schedule.
+func applySchedule(c *AccessPointConfig, t time.Time) error {
+	services := apServices(c)
+	if ScheduleActive(c.Schedule, t) {
+		for _, s := range services {
+			err := startService(s)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for k := len(services) - 1; k >= 0; k-- {
+		err := stopService(services[k])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableApSchedule enables the schedule timer of the access point. The access
+// point services are not started on boot, the timer starts them when the
+// box is inside a window.
+func enableApSchedule(c *AccessPointConfig) error {
+	services := apServices(c)
+	for k := len(services) - 1; k >= 0; k-- {
+		err := disableService(services[k])
+		if err != nil {
+			return err
+		}
+	}
+	timer := fmt.Sprintf(apScheduleTimer, c.Interface)
+	err := startService(timer)
+	if err != nil {
+		return err
+	}
+	err = enableService(timer)
+	if err != nil {
+		return err
+	}
+	return applySchedule(c, time.Now())
+}
+
+//ApplyScheduleCMD turns the access point on or off according to its
+//schedule. It is run by the schedule timer, and does nothing when the access
+//point is disabled.
+func ApplyScheduleCMD(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	state, err := accessPointState(i)
+	if err != nil {
+		return err
+	}
+	if !state.Enabled {
+		fmt.Printf("access point on %s is disabled\n", i)
+		return nil
+	}
+	return applySchedule(state.Configg, time.Now())
+}
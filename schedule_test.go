@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+func TestAPWindow_Active(t *testing.T) {
+	// 2018-01-01 is a monday.
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2018, 1, day, c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+	weekdays := &APWindow{Days: []string{"weekdays"}, Start: "06:00", Stop: "22:00"}
+	night := &APWindow{Days: []string{"Fri", "sat"}, Start: "20:00", Stop: "02:00"}
+	sample := []struct {
+		w      *APWindow
+		t      time.Time
+		active bool
+	}{
+		{weekdays, at(1, "06:00"), true},
+		{weekdays, at(1, "05:59"), false},
+		{weekdays, at(5, "21:59"), true},
+		{weekdays, at(5, "22:00"), false},
+		{weekdays, at(6, "12:00"), false},
+		{night, at(5, "20:30"), true},
+		{night, at(6, "01:59"), true},
+		{night, at(7, "01:00"), true},
+		{night, at(7, "02:00"), false},
+		{night, at(5, "01:00"), false},
+		{&APWindow{Start: "06:00", Stop: "07:00"}, at(7, "06:30"), true},
+	}
+	for k, v := range sample {
+		if a := v.w.Active(v.t); a != v.active {
+			t.Errorf("%d: expected %v got %v", k, v.active, a)
+		}
+	}
+	if !ScheduleActive(nil, at(1, "03:00")) {
+		t.Error("expected the access point to be on without a schedule")
+	}
+	if ScheduleActive([]*APWindow{weekdays, night}, at(7, "03:00")) {
+		t.Error("expected the access point to be off")
+	}
+	for _, w := range []*APWindow{
+		{Days: []string{"someday"}, Start: "06:00", Stop: "07:00"},
+		{Start: "6am", Stop: "07:00"},
+		{Start: "06:00", Stop: "06:00"},
+	} {
+		_, _, _, err := w.parse()
+		if err == nil {
+			t.Errorf("%v: expected an error", w)
+		}
+	}
+}
+
+func TestScheduleTimer(t *testing.T) {
+	a := DefaultAccesPoint()
+	a.Schedule = []*APWindow{
+		{Days: []string{"weekdays"}, Start: "06:00", Stop: "22:00"},
+		{Days: []string{"Sat"}, Start: "18:30", Stop: "01:00"},
+		{Start: "12:00", Stop: "13:00"},
+	}
+	u, err := scheduleTimer{a}.ToSystemdUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _ := ioutil.ReadAll(unit.Serialize(u))
+	exp, err := ioutil.ReadFile("fixture/fconf-ap-schedule.timer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, o) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), string(o))
+	}
+}
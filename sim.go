@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//SIMStatus is the lock state of a SIM. The retries are -1 when the modem
+//does not report them.
+type SIMStatus struct {
+	State      string `json:"state"`
+	PINRetries int    `json:"pin_retries"`
+	PUKRetries int    `json:"puk_retries"`
+}
+
+var (
+	errNoPIN       = errors.New("fconf: the SIM needs a PIN, set pin in the configuration")
+	errPINRejected = errors.New("fconf: the SIM rejected the PIN, set the right pin in the configuration")
+)
+
+// SIM lock states reported by AT+CPIN?.
+const (
+	SIMReady = "READY"
+	SIMPIN   = "SIM PIN"
+	SIMPUK   = "SIM PUK"
+)
+
+//SIMStatus returns the lock state of the SIM, and the remaining attempts
+//when the modem reports them with a vendor command.
+func (p *ATPort) SIMStatus() (*SIMStatus, error) {
+	lines, err := p.Command("AT+CPIN?")
+	if err != nil {
+		return nil, err
+	}
+	v, ok := atValue(lines, "+CPIN:")
+	if !ok {
+		return nil, fmt.Errorf("fconf: unexpected reply to AT+CPIN? %v", lines)
+	}
+	s := &SIMStatus{State: v, PINRetries: -1, PUKRetries: -1}
+
+	// huawei replies ^CPIN: SIM PIN,3,10,3,10,3,10 with the attempts left
+	// for the current code, PUK, PIN, PUK2 and PIN2.
+	lines, err = p.Command("AT^CPIN?")
+	if err == nil {
+		if v, ok := atValue(lines, "^CPIN:"); ok {
+			f := strings.Split(v, ",")
+			if len(f) >= 4 {
+				s.PUKRetries, _ = strconv.Atoi(strings.TrimSpace(f[2]))
+				s.PINRetries, _ = strconv.Atoi(strings.TrimSpace(f[3]))
+				return s, nil
+			}
+		}
+	}
+	// quectel replies +QPINC: "SC",3,10
+	lines, err = p.Command(`AT+QPINC="SC"`)
+	if err == nil {
+		if v, ok := atValue(lines, "+QPINC:"); ok {
+			f := strings.Split(v, ",")
+			if len(f) >= 3 {
+				s.PINRetries, _ = strconv.Atoi(strings.TrimSpace(f[1]))
+				s.PUKRetries, _ = strconv.Atoi(strings.TrimSpace(f[2]))
+			}
+		}
+	}
+	return s, nil
+}
+
+//UnlockSIM enters pin when the SIM asks for it. It refuses to use the last
+//attempt, so that a wrong PIN does not lock the SIM with its PUK, and returns
+//errPINRejected when the SIM does not take the PIN.
+func (p *ATPort) UnlockSIM(pin string) error {
+	s, err := p.SIMStatus()
+	if err != nil {
+		return err
+	}
+	switch s.State {
+	case SIMReady:
+		return nil
+	case SIMPIN:
+	case SIMPUK:
+		return errors.New("fconf: the SIM is locked, it needs the PUK")
+	default:
+		return fmt.Errorf("fconf: the SIM needs %s", s.State)
+	}
+	if pin == "" {
+		return errNoPIN
+	}
+	if s.PINRetries == 1 {
+		return errors.New("fconf: one PIN attempt left, enter the PIN by hand")
+	}
+	_, err = p.Command(fmt.Sprintf(`AT+CPIN="%s"`, pin))
+	if _, ok := err.(*ATError); ok {
+		return errPINRejected
+	}
+	return err
+}
+
+// validPIN returns true if pin has 4 to 8 digits.
+func validPIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 8 {
+		return false
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// keepSecret is like keepState for files only root should read.
+func keepSecret(filename string, src []byte) error {
+	dir := stateDir()
+	err := checkDir(dir)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, filename)
+	err = ioutil.WriteFile(name, src, 0600)
+	if err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file.
+	return os.Chmod(name, 0600)
+}
+
+// savePIN keeps the PIN of the SIM in the modem key out of the state file,
+// which anyone can read. key is the IMSI of a 3g modem or the interface of a
+// 4g modem.
+func savePIN(key, pin string) error {
+	if !validPIN(pin) {
+		return errors.New("fconf: pin must have 4 to 8 digits")
+	}
+	err := keepSecret(fmt.Sprintf(defaultPINSecret, key), []byte(pin))
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(stateDir(), fmt.Sprintf(defaultPINRejected, key)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readPIN returns the PIN of the SIM in the modem key, or an empty string when
+// there is none.
+func readPIN(key string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(stateDir(), fmt.Sprintf(defaultPINSecret, key)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// removePIN removes the PIN of the SIM in the modem key.
+func removePIN(key string) error {
+	for _, f := range []string{defaultPINSecret, defaultPINRejected} {
+		name := filepath.Join(stateDir(), fmt.Sprintf(f, key))
+		_, err := os.Stat(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		err = removeFile(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pinRejected returns true if the SIM in the modem key rejected its saved PIN.
+func pinRejected(key string) bool {
+	_, err := os.Stat(filepath.Join(stateDir(), fmt.Sprintf(defaultPINRejected, key)))
+	return err == nil
+}
+
+// rejectPIN marks the saved PIN of the SIM in the modem key as wrong. Every
+// wrong attempt brings the SIM closer to needing its PUK, so the PIN is not
+// entered again until it is configured again.
+func rejectPIN(key string) error {
+	return keepSecret(fmt.Sprintf(defaultPINRejected, key), nil)
+}
+
+// withSIMPort opens the AT port of the modem with the SIM imsi and calls fn.
+// The port configured for the modem wins over the /dev/<imsi>.imsi link.
+func withSIMPort(imsi string, fn func(*ATPort) error) error {
+	if imsi == "" {
+		return errors.New("fconf: missing imsi of the SIM")
+	}
+	dev := fmt.Sprintf(modemDevice, imsi)
+	if s, err := threeGState(imsi); err == nil {
+		dev = s.Configg.device()
+	}
+	p, err := OpenATPort(dev)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = p.Close()
+	}()
+	return fn(p)
+}
+
+// simStatus prints the lock state of the SIM with the given IMSI as json.
+func simStatus(imsi string) error {
+	return withSIMPort(imsi, func(p *ATPort) error {
+		s, err := p.SIMStatus()
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	})
+}
+
+// unlockSIM enters the saved PIN of the SIM with the given IMSI when the SIM
+// asks for it.
+func unlockSIM(imsi string) error {
+	pin, err := readPIN(imsi)
+	if err != nil {
+		return err
+	}
+	if pin == "" {
+		// nothing to enter, do not touch the port of a SIM without a PIN.
+		return nil
+	}
+	return withSIMPort(imsi, func(p *ATPort) error {
+		return enterPIN(p, imsi, pin)
+	})
+}
+
+// enterPIN unlocks the SIM on p with the saved pin of the modem key. A PIN the
+// SIM rejected once is not entered again.
+func enterPIN(p *ATPort, key, pin string) error {
+	rejected := pinRejected(key)
+	if rejected {
+		pin = ""
+	}
+	err := p.UnlockSIM(pin)
+	switch {
+	case err == errNoPIN && rejected:
+		return errPINRejected
+	case err == errPINRejected:
+		rerr := rejectPIN(key)
+		if rerr != nil {
+			return rerr
+		}
+	}
+	return err
+}
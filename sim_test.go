@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSIMStatus(t *testing.T) {
+	sample := []struct {
+		replies map[string]string
+		status  *SIMStatus
+	}{
+		{map[string]string{
+			"AT+CPIN?": "+CPIN: SIM PIN\nOK",
+			"AT^CPIN?": "^CPIN: SIM PIN,3,10,3,10,3,10\nOK",
+		}, &SIMStatus{State: SIMPIN, PINRetries: 3, PUKRetries: 10}},
+		{map[string]string{
+			"AT+CPIN?":      "+CPIN: READY\nOK",
+			`AT+QPINC="SC"`: `+QPINC: "SC",2,10` + "\nOK",
+		}, &SIMStatus{State: SIMReady, PINRetries: 2, PUKRetries: 10}},
+		{map[string]string{
+			"AT+CPIN?": "+CPIN: SIM PUK\nOK",
+		}, &SIMStatus{State: SIMPUK, PINRetries: -1, PUKRetries: -1}},
+	}
+	for _, v := range sample {
+		s, err := NewATPort(&fakeModem{replies: v.replies}).SIMStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s, v.status) {
+			t.Errorf("expected %+v got %+v", v.status, s)
+		}
+	}
+}
+
+func TestUnlockSIM(t *testing.T) {
+	m := &fakeModem{replies: map[string]string{
+		"AT+CPIN?":       "+CPIN: READY\nOK",
+		`AT+CPIN="1234"`: "OK",
+	}}
+	err := NewATPort(m).UnlockSIM("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range m.sent {
+		if c == `AT+CPIN="1234"` {
+			t.Error("the PIN was sent to an unlocked SIM")
+		}
+	}
+
+	m.replies["AT+CPIN?"] = "+CPIN: SIM PIN\nOK"
+	m.sent = nil
+	err = NewATPort(m).UnlockSIM("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.sent[len(m.sent)-1] != `AT+CPIN="1234"` {
+		t.Errorf("expected the PIN to be sent got %q", m.sent)
+	}
+
+	m.replies["AT^CPIN?"] = "^CPIN: SIM PIN,1,10,1,10,3,10\nOK"
+	m.sent = nil
+	err = NewATPort(m).UnlockSIM("1234")
+	if err == nil {
+		t.Error("expected an error with one attempt left")
+	}
+	if m.sent[len(m.sent)-1] == `AT+CPIN="1234"` {
+		t.Error("the last PIN attempt was used")
+	}
+}
+
+func TestEnterPIN(t *testing.T) {
+	testStateDir(t)
+
+	key := "639030000000002"
+	m := &fakeModem{replies: map[string]string{
+		"AT+CPIN?":       "+CPIN: SIM PIN\nOK",
+		`AT+CPIN="1234"`: "+CME ERROR: incorrect password",
+	}}
+	err := enterPIN(NewATPort(m), key, "1234")
+	if err != errPINRejected {
+		t.Fatalf("expected %v got %v", errPINRejected, err)
+	}
+	if !pinRejected(key) {
+		t.Error("expected the PIN to be marked as rejected")
+	}
+
+	// a rejected PIN is not entered again.
+	m.sent = nil
+	err = enterPIN(NewATPort(m), key, "1234")
+	if err != errPINRejected {
+		t.Errorf("expected %v got %v", errPINRejected, err)
+	}
+	for _, c := range m.sent {
+		if c == `AT+CPIN="1234"` {
+			t.Error("the rejected PIN was sent again")
+		}
+	}
+
+	// configuring the PIN again clears the mark.
+	err = savePIN(key, "4321")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinRejected(key) {
+		t.Error("expected saving the PIN to clear the rejected mark")
+	}
+}
+
+func TestSavePIN(t *testing.T) {
+	dir := testStateDir(t)
+
+	if err := savePIN("639030000000002", "12a4"); err == nil {
+		t.Error("expected an error for a bad pin")
+	}
+	err := savePIN("639030000000002", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(filepath.Join(dir, "pin@639030000000002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 got %v", st.Mode().Perm())
+	}
+	pin, err := readPIN("639030000000002")
+	if err != nil || pin != "1234" {
+		t.Errorf("expected 1234 got %q %v", pin, err)
+	}
+	err = removePIN("639030000000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, err = readPIN("639030000000002")
+	if err != nil || pin != "" {
+		t.Errorf("expected no pin got %q %v", pin, err)
+	}
+
+	// without a pin the modem, which is not configured here, is not opened.
+	err = unlockSIM("639030000000002")
+	if err != nil {
+		t.Errorf("expected no error without a pin got %v", err)
+	}
+}
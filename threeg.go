@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
 
+	"github.com/coreos/go-systemd/unit"
 	"github.com/urfave/cli"
 )
 
@@ -22,40 +25,283 @@ type ThreeG struct {
 	Username       string `json:"username"`
 	Password       string `json:"password"`
 	DefaultGateway bool   `json:"defaultGateway"`
+
+	// PPPUnit is the number of the ppp interface of the modem, it is picked
+	// when the modem is configured so that every modem gets its own
+	// interface.
+	PPPUnit *int `json:"ppp_unit,omitempty"`
+
+	// Metric is the metric of the default route through the modem, it
+	// defaults to 200 plus PPPUnit.
+	Metric int `json:"metric,omitempty"`
+
+	// Backend is wvdial, pppd or modemmanager, defaults to wvdial.
+	Backend string `json:"backend,omitempty"`
+
+	// APNProfile picks the profile of the operator used to fill in missing
+	// APN settings, when the operator has several.
+	APNProfile string `json:"apn_profile,omitempty"`
+
+	// PIN unlocks the SIM before dialing. It is kept in a file only root can
+	// read and never in the state file.
+	PIN string `json:"pin,omitempty"`
+
+	// Optional dialer settings, the dialer defaults are used when they are
+	// not set. Init are extra modem init strings sent after the APN, Modem
+	// overrides the /dev/<imsi>.imsi command port and IdleSeconds hangs up
+	// after that long without traffic. StupidMode and CarrierCheck are only
+	// used by wvdial.
+	Init          []string `json:"init,omitempty"`
+	Baud          int      `json:"baud,omitempty"`
+	Modem         string   `json:"modem,omitempty"`
+	StupidMode    *bool    `json:"stupid_mode,omitempty"`
+	AutoReconnect *bool    `json:"auto_reconnect,omitempty"`
+	IdleSeconds   int      `json:"idle_seconds,omitempty"`
+	CarrierCheck  *bool    `json:"carrier_check,omitempty"`
+	AutoDNS       *bool    `json:"auto_dns,omitempty"`
+
+	// pppd backend settings. ReplaceDefaultRoute replaces a default route
+	// pppd did not add, MaxFail is the number of failed dials before pppd
+	// gives up and the LCP echo settings detect a dead link.
+	ReplaceDefaultRoute bool `json:"replace_default_route,omitempty"`
+	MaxFail             int  `json:"max_fail,omitempty"`
+	LCPEchoInterval     int  `json:"lcp_echo_interval,omitempty"`
+	LCPEchoFailure      int  `json:"lcp_echo_failure,omitempty"`
+
+	// Cap disables the modem when it used its data, see fconf usage.
+	Cap *DataCap `json:"cap,omitempty"`
 }
 
-func (c *ThreeG) WriteTo(out io.Writer) error {
-	cfgTpl := `
-[Dialer Defaults]
+const (
+	wvdialService = "wvdial@%s"
+	wvdialUnit    = "wvdial@.service"
+	pppdWrapper   = "/etc/ppp/fconf-pppd-%s"
+	pppIPUp       = "/etc/ppp/ip-up.d/fconf-3g"
+)
+
+var wvdialDefaults = `[Dialer Defaults]
+Init1 = ATZ
 Init2 = ATQ0 V1 E1 S0=0 &C1 &D2
 Modem Type = USB Modem
+ISDN = 0
+Baud = 115200
+Stupid Mode = 1
+New PPPD = yes
+`
+
+func (c *ThreeG) unit() int {
+	if c.PPPUnit == nil {
+		return 0
+	}
+	return *c.PPPUnit
+}
+
+func (c *ThreeG) metric() int {
+	if c.Metric != 0 {
+		return c.Metric
+	}
+	return 200 + c.unit()
+}
+
+func (c *ThreeG) mmTarget() *mmTarget {
+	return &mmTarget{
+		IMEI:         c.IMEI,
+		IMSI:         c.IMSI,
+		APN:          c.APN,
+		Username:     c.Username,
+		Password:     c.Password,
+		Metric:       c.metric(),
+		DefaultRoute: c.DefaultGateway,
+	}
+}
+
+// maxWvdialInit is the number of extra init strings, wvdial reads Init1 to
+// Init9 and the first three are ours.
+const maxWvdialInit = 6
+
+var wvdialTpl = template.Must(template.New("wvdial").Funcs(template.FuncMap{
+	"onoff": wvdialBool,
+	"init": func(i int) int {
+		return i + 4
+	},
+}).Parse(`
+[Dialer {{.IMSI}}]
 ; Phone = <Target Phone Number>
 Phone = {{.Dial}}
-ISDN = 0
 ; Username = <Your Login Name>, 0 if not specified
-Username = {{.Username}}
+Username = {{or .Username "0"}}
 ; Password = <Your Password>, 0 if not specified
-Password = {{.Password}}
-Init1 = ATZ
+Password = {{or .Password "0"}}
 ; modem command port (by IMSI)
-Modem = /dev/{{.IMSI}}.imsi
-Baud = 115200
+Modem = {{.Device}}
 ; set APN
 Init3 = AT+CGDCONT=1,"IP","{{.APN}}"
-Stupid Mode = 1
-	`
-	tpl, err := template.New("cfg").Parse(cfgTpl)
+{{- range $i, $s := .Init}}
+Init{{init $i}} = {{$s}}
+{{- end}}
+{{- if .Baud}}
+Baud = {{.Baud}}
+{{- end}}
+{{- if .StupidMode}}
+Stupid Mode = {{onoff .StupidMode}}
+{{- end}}
+{{- if .AutoReconnect}}
+Auto Reconnect = {{onoff .AutoReconnect}}
+{{- end}}
+{{- if .IdleSeconds}}
+Idle Seconds = {{.IdleSeconds}}
+{{- end}}
+{{- if .CarrierCheck}}
+Carrier Check = {{onoff .CarrierCheck}}
+{{- end}}
+{{- if .AutoDNS}}
+Auto DNS = {{onoff .AutoDNS}}
+{{- end}}
+; pppd with the ppp unit of the modem
+PPPD Path = {{.PPPD}}
+`))
+
+func wvdialBool(b *bool) string {
+	if *b {
+		return "on"
+	}
+	return "off"
+}
+
+// device returns the AT command port of the modem.
+func (c *ThreeG) device() string {
+	if c.Modem != "" {
+		return c.Modem
+	}
+	return fmt.Sprintf(modemDevice, c.IMSI)
+}
+
+// validIMSI returns true if imsi is 5 to 15 digits. The IMSI names files,
+// systemd instances and the patterns of the ppp scripts run as root.
+func validIMSI(imsi string) bool {
+	if len(imsi) < 5 || len(imsi) > 15 {
+		return false
+	}
+	for _, r := range imsi {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the values written in wvdial configuration, which has no
+// quoting.
+func (c *ThreeG) validate() error {
+	if !validIMSI(c.IMSI) {
+		return fmt.Errorf("fconf: bad imsi %q, it must be 5 to 15 digits", c.IMSI)
+	}
+	for k, v := range map[string]string{
+		"imsi": c.IMSI, "apn": c.APN, "dial": c.Dial, "username": c.Username,
+		"password": c.Password, "modem": c.Modem,
+	} {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("fconf: %s can not have line breaks", k)
+		}
+	}
+	if strings.Contains(c.APN, `"`) {
+		return errors.New("fconf: apn can not have quotes")
+	}
+	if len(c.Init) > maxWvdialInit {
+		return fmt.Errorf("fconf: at most %d init strings", maxWvdialInit)
+	}
+	for _, v := range c.Init {
+		if v == "" || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("fconf: bad init string %q", v)
+		}
+	}
+	if c.Baud < 0 || c.IdleSeconds < 0 || c.MaxFail < 0 ||
+		c.LCPEchoInterval < 0 || c.LCPEchoFailure < 0 {
+		return errors.New("fconf: baud, idle_seconds, max_fail and lcp_echo settings can not be negative")
+	}
+	err := c.Cap.validate()
 	if err != nil {
 		return err
 	}
-	ctx := *c
-	if ctx.Username == "" {
-		ctx.Username = "0"
+	switch c.Backend {
+	case "", BackendWvdial, BackendModemManager:
+	case BackendPPPD:
+		// chat scripts quote the AT commands with single quotes.
+		if strings.ContainsAny(c.APN+c.Dial+strings.Join(c.Init, ""), "'") {
+			return errors.New("fconf: apn, dial and init strings can not have single quotes with the pppd backend")
+		}
+	default:
+		return fmt.Errorf("fconf: unknown backend %s", c.Backend)
 	}
-	if ctx.Password == "" {
-		ctx.Password = "0"
+	return nil
+}
+
+// service returns the systemd service that connects the modem.
+func (c *ThreeG) service() string {
+	switch c.Backend {
+	case BackendModemManager:
+		return fmt.Sprintf(mmService, c.IMSI)
+	case BackendPPPD:
+		return fmt.Sprintf(pppdService, c.IMSI)
+	}
+	return fmt.Sprintf(wvdialService, c.IMSI)
+}
+
+//WriteTo writes the wvdial section of the modem, named after its IMSI.
+func (c *ThreeG) WriteTo(dst io.Writer) (int64, error) {
+	ctx := struct {
+		*ThreeG
+		Device string
+		PPPD   string
+	}{c, c.device(), fmt.Sprintf(pppdWrapper, c.IMSI)}
+	var buf bytes.Buffer
+	err := wvdialTpl.Execute(&buf, ctx)
+	if err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(dst)
+}
+
+//WriteWvdial writes wvdial configuration with a section for every modem.
+func WriteWvdial(out io.Writer, modems []*ThreeG) error {
+	_, err := io.WriteString(out, wvdialDefaults)
+	if err != nil {
+		return err
+	}
+	for _, m := range modems {
+		_, err = m.WriteTo(out)
+		if err != nil {
+			return err
+		}
 	}
-	return tpl.Execute(out, ctx)
+	return nil
+}
+
+// writePPPD writes the script wvdial runs instead of pppd. It gives the modem
+// its own ppp interface and passes the IMSI to the ip-up script, which adds
+// the default route with the metric of the modem.
+func (c *ThreeG) writePPPD(dst io.Writer) error {
+	_, err := fmt.Fprintf(dst, `#!/bin/sh
+# written by fconf, pppd for the 3g modem %s
+exec /usr/sbin/pppd "$@" unit %d ipparam %s nodefaultroute
+`, c.IMSI, c.unit(), c.IMSI)
+	return err
+}
+
+// writeIPUp writes the pppd ip-up script that adds the default routes of the
+// modems.
+func writeIPUp(dst io.Writer, modems []*ThreeG) error {
+	var buf bytes.Buffer
+	buf.WriteString("#!/bin/sh\n# written by fconf, default routes of the 3g modems\ncase \"$6\" in\n")
+	for _, m := range modems {
+		if !m.DefaultGateway {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s)\n\tip route replace default dev \"$1\" metric %d\n\t;;\n", m.IMSI, m.metric())
+	}
+	buf.WriteString("esac\n")
+	_, err := buf.WriteTo(dst)
+	return err
 }
 
 type ThreeGState struct {
@@ -76,6 +322,32 @@ func ThreegCMD(ctx *cli.Context) error {
 	if ctx.IsSet(configFlag) {
 		return configThreegCMD(ctx)
 	}
+	if ctx.IsSet(listAPNsFlag) {
+		return listAPNs(getInterface(ctx))
+	}
+	if ctx.IsSet(simStatusFlag) {
+		return simStatus(getInterface(ctx))
+	}
+	if ctx.IsSet(unlockSIMFlag) {
+		return unlockSIM(getInterface(ctx))
+	}
+	if ctx.IsSet(mmConnectFlag) || ctx.IsSet(mmDisconnectFlag) || ctx.IsSet(statusFlag) {
+		i := getInterface(ctx)
+		if i == "" {
+			return errors.New("missing imsi, you must specify imsi")
+		}
+		e, err := threeGState(i)
+		if err != nil {
+			return err
+		}
+		t := e.Configg.mmTarget()
+		t.Key = i
+		t.PIN, err = readPIN(i)
+		if err != nil {
+			return err
+		}
+		return mmCMD(ctx, t)
+	}
 	return nil
 }
 
@@ -103,14 +375,63 @@ func configThreegCMD(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if e.IMSI == "" {
+		return errors.New("fconf: missing imsi")
+	}
+	if !validIMSI(e.IMSI) {
+		return fmt.Errorf("fconf: bad imsi %q, it must be 5 to 15 digits", e.IMSI)
+	}
+	if e.PIN != "" && e.Backend == BackendModemManager && e.IMEI == "" {
+		return errors.New("fconf: modemmanager backend needs the imei of the modem to unlock the SIM")
+	}
+	err = e.FillAPN()
+	if err != nil {
+		return err
+	}
+	err = e.validate()
+	if err != nil {
+		return err
+	}
 	err = checkDir(base)
 	if err != nil {
 		return err
 	}
 	state := &ThreeGState{Configg: &e}
-	ms, err := threeGState(e.IMEI)
+	ms, err := threeGState(e.IMSI)
 	if err == nil {
 		state.Enabled = ms.Enabled
+		if e.PPPUnit == nil {
+			e.PPPUnit = ms.Configg.PPPUnit
+		}
+		if ms.Enabled && ms.Configg.service() != e.service() {
+			// the old backend would keep dialing on the same port, the
+			// modem stays disabled until it is enabled with the new one.
+			err = stopThreeG(ms.Configg)
+			if err != nil {
+				return err
+			}
+			if ms.Configg.Backend == BackendPPPD {
+				err = removePPPDPeer(ms.Configg)
+				if err != nil {
+					return err
+				}
+			}
+			state.Enabled = false
+		}
+	}
+	if e.PPPUnit == nil {
+		u, err := freePPPUnit(e.IMSI)
+		if err != nil {
+			return err
+		}
+		e.PPPUnit = &u
+	}
+	if e.PIN != "" {
+		err = savePIN(e.IMSI, e.PIN)
+		if err != nil {
+			return err
+		}
+		e.PIN = ""
 	}
 	b, _ = json.Marshal(state)
 	setInterface(ctx, e.IMSI)
@@ -139,6 +460,153 @@ func threeGState(i string) (*ThreeGState, error) {
 	return f, nil
 }
 
+// threeGStates returns the state of all configured modems, ordered by IMSI.
+func threeGStates() ([]*ThreeGState, error) {
+	m, err := filepath.Glob(filepath.Join(stateDir(),
+		fmt.Sprintf(defaultThreeGGConfig, "*")))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(m)
+	var result []*ThreeGState
+	prefix := strings.Split(defaultThreeGGConfig, "%s")
+	for _, v := range m {
+		i := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(v), prefix[0]), prefix[1])
+		s, err := threeGState(i)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// freePPPUnit returns the lowest ppp unit not used by another modem than
+// imsi.
+func freePPPUnit(imsi string) (int, error) {
+	states, err := threeGStates()
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[int]bool)
+	for _, s := range states {
+		if s.Configg.IMSI != imsi && s.Configg.PPPUnit != nil {
+			used[*s.Configg.PPPUnit] = true
+		}
+	}
+	u := 0
+	for used[u] {
+		u++
+	}
+	return u, nil
+}
+
+// wvdialTemplate is the systemd template unit that dials the wvdial section
+// of a modem, the instance name is the IMSI. fconf enters the PIN first when
+// the SIM asks for it, so that redialing an unlocked SIM works. A failed unlock
+// does not stop the dial, wvdial reports the locked SIM.
+type wvdialTemplate struct {
+	bin string
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (w wvdialTemplate) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "wvdial 3g modem %i"),
+		unit.NewUnitOption("Unit", "After", "network.target"),
+		unit.NewUnitOption("Service", "ExecStartPre",
+			"-"+w.bin+" --interface %i 3g-ras --"+unlockSIMFlag),
+		unit.NewUnitOption("Service", "ExecStart",
+			"/usr/bin/wvdial --config "+filepath.Join(apConfigBase, threeGService)+" %i"),
+		unit.NewUnitOption("Service", "Restart", "always"),
+		unit.NewUnitOption("Service", "RestartSec", "10"),
+		unit.NewUnitOption("Install", "WantedBy", "multi-user.target"),
+	}, nil
+}
+
+// writeThreeG writes wvdial configuration and the ppp scripts for the enabled
+// modems, and the pppd peers of the modems using the pppd backend. The wvdial
+// configuration file is removed when no modem uses wvdial.
+func writeThreeG() error {
+	states, err := threeGStates()
+	if err != nil {
+		return err
+	}
+	var modems, peers []*ThreeG
+	for _, s := range states {
+		if !s.Enabled {
+			continue
+		}
+		switch s.Configg.Backend {
+		case BackendModemManager:
+		case BackendPPPD:
+			peers = append(peers, s.Configg)
+		default:
+			modems = append(modems, s.Configg)
+		}
+	}
+	err = writePPPDPeers(peers)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(apConfigBase, threeGService)
+	if len(modems) == 0 {
+		for _, f := range []string{name, pppIPUp} {
+			err = removeFile(f)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return reloadSystemd()
+	}
+	var buf bytes.Buffer
+	err = WriteWvdial(&buf, modems)
+	if err != nil {
+		return err
+	}
+	// the file has the passwords of the modems.
+	err = ioutil.WriteFile(name, buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("written 3g config to %s\n", name)
+	for _, m := range modems {
+		buf.Reset()
+		err = m.writePPPD(&buf)
+		if err != nil {
+			return err
+		}
+		f := fmt.Sprintf(pppdWrapper, m.IMSI)
+		err = checkDir(filepath.Dir(f))
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(f, buf.Bytes(), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	buf.Reset()
+	err = writeIPUp(&buf, modems)
+	if err != nil {
+		return err
+	}
+	err = checkDir(filepath.Dir(pppIPUp))
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(pppIPUp, buf.Bytes(), 0755)
+	if err != nil {
+		return err
+	}
+	err = CreateSystemdFile(wvdialTemplate{fconfBin()},
+		filepath.Join(systemdBase, wvdialUnit), 0644)
+	if err != nil {
+		return err
+	}
+	return reloadSystemd()
+}
+
 func EnableThreeg(ctx *cli.Context) error {
 	if ctx.IsSet(configFlag) {
 		err := configThreegCMD(ctx)
@@ -148,30 +616,33 @@ func EnableThreeg(ctx *cli.Context) error {
 	}
 	i := getInterface(ctx)
 	if i == "" {
-		return errors.New("missing imei , you must specify imei")
+		return errors.New("missing imsi , you must specify imsi")
 	}
 	e, err := threeGState(i)
 	if err != nil {
 		return err
 	}
-	name := filepath.Join(apConfigBase, threeGService)
-	_, err = os.Stat(name)
-	if err == nil {
-		if !e.Enabled {
-			return errors.New("you can not enable two 3g networks")
-		}
+	e.Enabled = true
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
 	}
-	var buf bytes.Buffer
-	err = e.Configg.WriteTo(&buf)
+	err = keepState(
+		fmt.Sprintf(defaultThreeGGConfig, i), data)
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(name, buf.Bytes(), 0644)
+	err = writeThreeG()
 	if err != nil {
 		return err
 	}
-	fmt.Printf("written 3g config to %s\n", name)
-	service := "wvdial"
+	service := e.Configg.service()
+	if e.Configg.Backend == BackendModemManager {
+		err = writeMMService("3g-ras", i)
+		if err != nil {
+			return err
+		}
+	}
 	err = restartService(service)
 	if err != nil {
 		return fmt.Errorf("ERROR: restarting systemd %v ", err)
@@ -180,14 +651,21 @@ func EnableThreeg(ctx *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("ERROR: enabling systemd %v ", err)
 	}
-	e.Enabled = true
-	data, err := json.Marshal(e)
+	fmt.Printf("successfully enabled 3g for %s \n", i)
+	return nil
+}
+
+// stopThreeG stops and disables the service that connects the modem.
+func stopThreeG(c *ThreeG) error {
+	if c.Backend == BackendModemManager {
+		return removeMMService(c.IMSI)
+	}
+	service := c.service()
+	err := stopService(service)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("successfully enabled 3g for %s \n", i)
-	return keepState(
-		fmt.Sprintf(defaultThreeGGConfig, i), data)
+	return disableService(service)
 }
 
 func DisableThreeg(ctx *cli.Context) error {
@@ -196,7 +674,7 @@ func DisableThreeg(ctx *cli.Context) error {
 	}
 	i := getInterface(ctx)
 	if i == "" {
-		return errors.New("missing imei, you must specify imei")
+		return errors.New("missing imsi, you must specify imsi")
 	}
 	e, err := threeGState(i)
 	if err != nil {
@@ -207,30 +685,37 @@ func DisableThreeg(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	service := "wvdial"
-	err = stopService(service)
+	err = stopThreeG(e.Configg)
 	if err != nil {
 		return err
 	}
-	err = disableService(service)
+	err = keepState(
+		fmt.Sprintf(defaultThreeGGConfig, i), data)
 	if err != nil {
 		return err
 	}
-
-	name := filepath.Join(apConfigBase, threeGService)
-	err = removeFile(name)
+	err = removeFile(fmt.Sprintf(pppdWrapper, i))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if e.Configg.Backend == BackendPPPD {
+		err = removePPPDPeer(e.Configg)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeThreeG()
 	if err != nil {
 		return err
 	}
 	fmt.Println("successfully disabled 3G for ", i)
-	return keepState(
-		fmt.Sprintf(defaultThreeGGConfig, i), data)
+	return nil
 }
 
 func RemoveThreeg(ctx *cli.Context) error {
 	i := getInterface(ctx)
 	if i == "" {
-		return errors.New("missing imei, you must specify imei")
+		return errors.New("missing imsi, you must specify imsi")
 	}
 
 	e, err := threeGState(i)
@@ -243,21 +728,12 @@ func RemoveThreeg(ctx *cli.Context) error {
 			return err
 		}
 	}
-	// removestate file
-	stateFile := filepath.Join(stateDir(),
-		fmt.Sprintf(defaultThreeGGConfig, i))
-	err = removeFile(stateFile)
+	err = removePIN(i)
 	if err != nil {
 		return err
 	}
-
-	// remove config
-	unit := filepath.Join(apConfigBase, threeGService)
-	err = removeFile(unit)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-	}
-	return nil
+	// removestate file
+	stateFile := filepath.Join(stateDir(),
+		fmt.Sprintf(defaultThreeGGConfig, i))
+	return removeFile(stateFile)
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteWvdial(t *testing.T) {
+	u0, u1 := 0, 1
+	modems := []*ThreeG{
+		{
+			IMSI:           "639020000000001",
+			APN:            "safaricom",
+			Dial:           "*99#",
+			Username:       "saf",
+			Password:       "data",
+			DefaultGateway: true,
+			PPPUnit:        &u0,
+		},
+		{
+			IMSI:           "639030000000002",
+			APN:            "internet",
+			Dial:           "*99#",
+			DefaultGateway: true,
+			PPPUnit:        &u1,
+			Metric:         300,
+		},
+	}
+	var buf bytes.Buffer
+	err := WriteWvdial(&buf, modems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ioutil.ReadFile("fixture/fconf-wvdial.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, buf.Bytes()) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), buf.String())
+	}
+
+	buf.Reset()
+	err = writeIPUp(&buf, modems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err = ioutil.ReadFile("fixture/fconf-3g-ip-up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, buf.Bytes()) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), buf.String())
+	}
+}
+
+func TestWvdialOptions(t *testing.T) {
+	u, on, off := 2, true, false
+	m := &ThreeG{
+		IMSI:          "621300000000003",
+		APN:           "web.gprs.mtnnigeria.net",
+		Dial:          "*99***1#",
+		Username:      "web",
+		Password:      "p&ss<word>",
+		PPPUnit:       &u,
+		Init:          []string{"AT+CFUN=1", "AT^SYSCFG=2,2,3FFFFFFF,1,2"},
+		Baud:          460800,
+		Modem:         "/dev/ttyUSB2",
+		StupidMode:    &off,
+		AutoReconnect: &on,
+		IdleSeconds:   300,
+		CarrierCheck:  &off,
+		AutoDNS:       &on,
+	}
+	err := m.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = m.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ioutil.ReadFile("fixture/fconf-wvdial-options.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, buf.Bytes()) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), buf.String())
+	}
+
+	sample := []*ThreeG{
+		{IMSI: "*"},
+		{IMSI: "../../etc/x"},
+		{IMSI: "1234"},
+		{IMSI: "6213000000000031"},
+		{IMSI: "621300000000003", APN: "a\nInit9 = ATH"},
+		{IMSI: "621300000000003", APN: `a"b`},
+		{IMSI: "621300000000003", Init: []string{"AT", "AT", "AT", "AT", "AT", "AT", "AT"}},
+		{IMSI: "621300000000003", Init: []string{""}},
+		{IMSI: "621300000000003", Baud: -1},
+	}
+	for _, v := range sample {
+		if v.validate() == nil {
+			t.Errorf("expected %+v to be invalid", v)
+		}
+	}
+}
+
+func TestFreePPPUnit(t *testing.T) {
+	dir := testStateDir(t)
+	for imsi, u := range map[string]int{"1001": 0, "1002": 2} {
+		u := u
+		b, _ := json.Marshal(&ThreeGState{Configg: &ThreeG{IMSI: imsi, PPPUnit: &u}})
+		err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf(defaultThreeGGConfig, imsi)), b, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sample := []struct {
+		imsi string
+		unit int
+	}{
+		{"1003", 1},
+		{"1001", 0},
+		{"1002", 1},
+	}
+	for _, v := range sample {
+		u, err := freePPPUnit(v.imsi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != v.unit {
+			t.Errorf("%s: expected unit %d got %d", v.imsi, v.unit, u)
+		}
+	}
+}
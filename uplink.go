@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/urfave/cli"
+)
+
+// uplink is a configured cellular connection, a 3g modem keyed by its IMSI or
+// a 4g modem keyed by its interface.
+type uplink struct {
+	cmd     string
+	key     string
+	Enabled bool
+	Cap     *DataCap
+	threeG  *ThreeG
+	fourG   *FourG
+}
+
+// uplinks returns the configured 3g and 4g modems.
+func uplinks() ([]*uplink, error) {
+	var result []*uplink
+	t, err := threeGStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range t {
+		result = append(result, &uplink{
+			cmd: "3g-ras", key: s.Configg.IMSI, Enabled: s.Enabled,
+			Cap: s.Configg.Cap, threeG: s.Configg,
+		})
+	}
+	f, err := fourGStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range f {
+		result = append(result, &uplink{
+			cmd: "4g-ndis", key: s.Configg.Interface, Enabled: s.Enabled,
+			Cap: s.Configg.Cap, fourG: s.Configg,
+		})
+	}
+	return result, nil
+}
+
+// name returns the fconf command and key of the uplink, like
+// 3g-ras-639020000000001.
+func (u *uplink) name() string {
+	return u.cmd + "-" + u.key
+}
+
+// iface returns the network interface of the uplink. 3g modems dialed with
+// ppp use the ppp unit of the modem, ModemManager knows the interface of its
+// modems.
+func (u *uplink) iface() (string, error) {
+	if u.fourG != nil {
+		return u.fourG.Interface, nil
+	}
+	if u.threeG.Backend == BackendModemManager {
+		m, err := FindMMModem(u.threeG.IMEI, u.threeG.IMSI)
+		if err != nil {
+			return "", err
+		}
+		if m.Bearer == nil || !m.Bearer.Connected {
+			return "", errors.New("fconf: modem is not connected")
+		}
+		return m.Bearer.Interface, nil
+	}
+	return fmt.Sprintf("ppp%d", u.threeG.unit()), nil
+}
+
+// service returns the systemd service that connects the uplink. ndis modems
+// connect on their own, systemd-networkd configures them.
+func (u *uplink) service() string {
+	if u.threeG != nil {
+		return u.threeG.service()
+	}
+	switch {
+	case u.fourG.Backend == BackendModemManager:
+		return fmt.Sprintf(mmService, u.key)
+	case u.fourG.Mode == ModeQMI || u.fourG.Mode == ModeMBIM:
+		return fmt.Sprintf(wwanService, u.key)
+	}
+	return "systemd-networkd"
+}
+
+// restart restarts the connection of the uplink. ndis modems share
+// systemd-networkd with the other interfaces, only their link is configured
+// again.
+func (u *uplink) restart() error {
+	s := u.service()
+	if s != "systemd-networkd" {
+		return restartService(s)
+	}
+	o, err := exec.Command("networkctl", "reconfigure", u.key).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fconf: networkctl reconfigure %s %v %s", u.key, err, o)
+	}
+	return nil
+}
+
+// lockUplinks serializes enabling and disabling uplinks between the watchdog
+// and fconf usage --sample, so that one does not undo what the other just
+// did.
+func lockUplinks() (*os.File, error) {
+	return lockState("uplinks")
+}
+
+// uplinkContext returns a context like the one of fconf --interface key, to
+// call the enable and disable functions of the commands.
+func uplinkContext(key string) *cli.Context {
+	global := flag.NewFlagSet("fconf", flag.ContinueOnError)
+	global.String("interface", "", "")
+	_ = global.Set("interface", key)
+	set := flag.NewFlagSet("uplink", flag.ContinueOnError)
+	return cli.NewContext(nil, set, cli.NewContext(nil, global, nil))
+}
+
+// enable enables the uplink with the enable function of its command.
+func (u *uplink) enable() error {
+	if u.fourG != nil {
+		return EnableFourg(uplinkContext(u.key))
+	}
+	return EnableThreeg(uplinkContext(u.key))
+}
+
+// disable disables the uplink with the disable function of its command.
+func (u *uplink) disable() error {
+	if u.fourG != nil {
+		return DisableFourg(uplinkContext(u.key))
+	}
+	return DisableThreeg(uplinkContext(u.key))
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/urfave/cli"
+)
+
+const (
+	usageService = "fconf-usage.service"
+	usageTimer   = "fconf-usage.timer"
+
+	// usageDays is how many days of daily totals are kept.
+	usageDays = 400
+	dayLayout = "2006-01-02"
+	mb        = 1000 * 1000
+)
+
+// sysClassNet and bootIDFile are variables so tests can use fake interface
+// statistics and reboots.
+var (
+	sysClassNet = "/sys/class/net"
+	bootIDFile  = "/proc/sys/kernel/random/boot_id"
+)
+
+//DataCap limits the data an uplink uses, it is disabled when a limit is
+//reached and enabled again when the day or the month ends. ResetDay is the
+//day of the month the data bundle renews, defaults to 1.
+type DataCap struct {
+	DailyMB   int64 `json:"daily_mb,omitempty"`
+	MonthlyMB int64 `json:"monthly_mb,omitempty"`
+	ResetDay  int   `json:"reset_day,omitempty"`
+}
+
+// validate checks the limits, a nil cap is fine.
+func (c *DataCap) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.DailyMB < 0 || c.MonthlyMB < 0 {
+		return errors.New("fconf: cap limits can not be negative")
+	}
+	if c.ResetDay < 0 || c.ResetDay > 31 {
+		return errors.New("fconf: cap reset_day must be a day of the month")
+	}
+	return nil
+}
+
+//Usage is the data received and sent over an uplink.
+type Usage struct {
+	RX uint64 `json:"rx_bytes"`
+	TX uint64 `json:"tx_bytes"`
+}
+
+func (u Usage) total() uint64 {
+	return u.RX + u.TX
+}
+
+//UsageState is the data used by an uplink, by day. Last are the interface
+//counters at the last sample, they start over when the interface comes back
+//or the box reboots, which changes IfIndex or BootID.
+type UsageState struct {
+	Interface string            `json:"interface"`
+	IfIndex   int               `json:"ifindex,omitempty"`
+	BootID    string            `json:"boot_id,omitempty"`
+	Last      Usage             `json:"last"`
+	Days      map[string]*Usage `json:"days"`
+	Capped    bool              `json:"capped,omitempty"`
+}
+
+// counters are the statistics of an interface and what identifies them, the
+// kernel starts new counters for a new interface or after a reboot.
+type counters struct {
+	Usage
+	Interface string
+	IfIndex   int
+	BootID    string
+}
+
+// same returns true if c continues the counters of the last sample. States
+// saved without ifindex or boot id only compare the interface name.
+func (s *UsageState) same(c counters) bool {
+	return c.Interface == s.Interface &&
+		(s.IfIndex == 0 || c.IfIndex == s.IfIndex) &&
+		(s.BootID == "" || c.BootID == s.BootID) &&
+		c.RX >= s.Last.RX && c.TX >= s.Last.TX
+}
+
+// add counts the data used since the last sample of the interface counters c.
+func (s *UsageState) add(c counters, now time.Time) {
+	d := c.Usage
+	if s.same(c) {
+		d.RX -= s.Last.RX
+		d.TX -= s.Last.TX
+	}
+	if s.Days == nil {
+		s.Days = make(map[string]*Usage)
+	}
+	day := now.Format(dayLayout)
+	u, ok := s.Days[day]
+	if !ok {
+		u = &Usage{}
+		s.Days[day] = u
+	}
+	u.RX += d.RX
+	u.TX += d.TX
+	s.Interface = c.Interface
+	s.IfIndex = c.IfIndex
+	s.BootID = c.BootID
+	s.Last = c.Usage
+	old := now.AddDate(0, 0, -usageDays).Format(dayLayout)
+	for k := range s.Days {
+		if k < old {
+			delete(s.Days, k)
+		}
+	}
+}
+
+// periodStart returns the first day of the month, or data bundle, now is in.
+func periodStart(now time.Time, resetDay int) time.Time {
+	if resetDay < 1 {
+		resetDay = 1
+	}
+	day := func(y int, m time.Month) time.Time {
+		// the bundle of the 31st renews on the last day of short months.
+		last := time.Date(y, m+1, 0, 0, 0, 0, 0, now.Location()).Day()
+		d := resetDay
+		if d > last {
+			d = last
+		}
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	}
+	s := day(now.Year(), now.Month())
+	if now.Before(s) {
+		s = day(now.Year(), now.Month()-1)
+	}
+	return s
+}
+
+// since returns the data used from the day start on.
+func (s *UsageState) since(start time.Time) Usage {
+	var u Usage
+	k := start.Format(dayLayout)
+	for d, v := range s.Days {
+		if d >= k {
+			u.RX += v.RX
+			u.TX += v.TX
+		}
+	}
+	return u
+}
+
+// reached returns true if the uplink used all the data of the day or month.
+func (c *DataCap) reached(s *UsageState, now time.Time) bool {
+	if c == nil {
+		return false
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if c.DailyMB > 0 && s.since(day).total() >= uint64(c.DailyMB)*mb {
+		return true
+	}
+	start := periodStart(now, c.ResetDay)
+	return c.MonthlyMB > 0 && s.since(start).total() >= uint64(c.MonthlyMB)*mb
+}
+
+// capAction returns whether the uplink must be disabled because it reached its
+// cap, or enabled because fconf disabled it and a new day or month started.
+func (s *UsageState) capAction(c *DataCap, enabled bool, now time.Time) (disable, enable bool) {
+	over := c.reached(s, now)
+	switch {
+	case over && enabled:
+		return true, false
+	case !over && !enabled && s.Capped:
+		return false, true
+	}
+	return false, false
+}
+
+// readCounters returns the statistics of the interface during the boot with
+// the given id.
+func readCounters(iface, boot string) (counters, error) {
+	c := counters{Interface: iface, BootID: boot}
+	var index uint64
+	for _, v := range []struct {
+		name string
+		dst  *uint64
+	}{
+		{"statistics/rx_bytes", &c.RX},
+		{"statistics/tx_bytes", &c.TX},
+		{"ifindex", &index},
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(sysClassNet, iface, v.name))
+		if err != nil {
+			return c, err
+		}
+		*v.dst, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return c, err
+		}
+	}
+	c.IfIndex = int(index)
+	return c, nil
+}
+
+// bootID returns the id the kernel picks on every boot, "" when unknown.
+func bootID() string {
+	b, err := ioutil.ReadFile(bootIDFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func usageState(name string) (*UsageState, error) {
+	s := &UsageState{Days: make(map[string]*Usage)}
+	b, err := ioutil.ReadFile(filepath.Join(stateDir(), fmt.Sprintf(defaultUsageState, name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// sampleUsage counts the data used by the enabled uplinks since the last
+// sample, and disables or enables the uplinks with a cap. It holds the uplinks
+// lock so that the watchdog does not change them meanwhile.
+func sampleUsage(now time.Time) error {
+	l, err := lockUplinks()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	ups, err := uplinks()
+	if err != nil {
+		return err
+	}
+	boot := bootID()
+	for _, u := range ups {
+		s, err := usageState(u.name())
+		if err != nil {
+			return err
+		}
+		if u.Enabled {
+			iface, err := u.iface()
+			if err == nil {
+				var c counters
+				c, err = readCounters(iface, boot)
+				if err == nil {
+					s.add(c, now)
+				}
+			}
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("usage: %s %v", u.name(), err)
+			}
+		}
+		// an uplink that fails to change keeps its capped state, the next
+		// sample tries again. Its usage is saved either way.
+		disable, enable := s.capAction(u.Cap, u.Enabled, now)
+		switch {
+		case disable:
+			log.Printf("usage: %s reached its data cap, disabling it", u.name())
+			err = u.disable()
+			if err != nil {
+				log.Printf("usage: disabling %s %v", u.name(), err)
+			} else {
+				s.Capped = true
+			}
+		case enable:
+			log.Printf("usage: %s has data again, enabling it", u.name())
+			err = u.enable()
+			if err != nil {
+				log.Printf("usage: enabling %s %v", u.name(), err)
+			} else {
+				s.Capped = false
+			}
+		case u.Enabled:
+			s.Capped = false
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		err = keepState(fmt.Sprintf(defaultUsageState, u.name()), b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//UsageReport is the data used by an uplink in the days of the current month
+//and in the past months, keyed by their first day.
+type UsageReport struct {
+	Uplink    string           `json:"uplink"`
+	Interface string           `json:"interface"`
+	Daily     map[string]Usage `json:"daily"`
+	Monthly   map[string]Usage `json:"monthly"`
+	Cap       *DataCap         `json:"cap,omitempty"`
+	Capped    bool             `json:"capped"`
+}
+
+func (s *UsageState) report(c *DataCap, now time.Time) *UsageReport {
+	r := &UsageReport{
+		Interface: s.Interface,
+		Daily:     make(map[string]Usage),
+		Monthly:   make(map[string]Usage),
+		Cap:       c,
+		Capped:    s.Capped,
+	}
+	resetDay := 1
+	if c != nil {
+		resetDay = c.ResetDay
+	}
+	current := periodStart(now, resetDay).Format(dayLayout)
+	for k, v := range s.Days {
+		d, err := time.ParseInLocation(dayLayout, k, now.Location())
+		if err != nil {
+			continue
+		}
+		p := periodStart(d, resetDay).Format(dayLayout)
+		m := r.Monthly[p]
+		m.RX += v.RX
+		m.TX += v.TX
+		r.Monthly[p] = m
+		if p == current {
+			r.Daily[k] = *v
+		}
+	}
+	return r
+}
+
+// usageReport prints the data used by all uplinks as json.
+func usageReport(now time.Time) error {
+	ups, err := uplinks()
+	if err != nil {
+		return err
+	}
+	var result []*UsageReport
+	for _, u := range ups {
+		s, err := usageState(u.name())
+		if err != nil {
+			return err
+		}
+		r := s.report(u.Cap, now)
+		r.Uplink = u.name()
+		result = append(result, r)
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// usageUnit samples the data usage, run every minute by usageTimerUnit.
+type usageUnit struct {
+	bin string
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (u usageUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf cellular data usage"),
+		unit.NewUnitOption("Service", "Type", "oneshot"),
+		unit.NewUnitOption("Service", "ExecStart", u.bin+" usage --"+sampleFlag),
+	}, nil
+}
+
+// usageTimerUnit samples often, traffic since the last sample is lost when a
+// ppp interface goes away.
+type usageTimerUnit struct{}
+
+//ToSystemdUnit implement UnitFile interface
+func (usageTimerUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf cellular data usage"),
+		unit.NewUnitOption("Timer", "OnBootSec", "1min"),
+		unit.NewUnitOption("Timer", "OnUnitActiveSec", "1min"),
+		unit.NewUnitOption("Timer", "Unit", usageService),
+		unit.NewUnitOption("Install", "WantedBy", "timers.target"),
+	}, nil
+}
+
+// enableUsage writes and starts the usage timer.
+func enableUsage() error {
+	err := CreateSystemdFile(usageUnit{fconfBin()},
+		filepath.Join(systemdBase, usageService), 0644)
+	if err != nil {
+		return err
+	}
+	err = CreateSystemdFile(usageTimerUnit{},
+		filepath.Join(systemdBase, usageTimer), 0644)
+	if err != nil {
+		return err
+	}
+	err = reloadSystemd()
+	if err != nil {
+		return err
+	}
+	err = startService(usageTimer)
+	if err != nil {
+		return err
+	}
+	return enableService(usageTimer)
+}
+
+// disableUsage stops and removes the usage timer, the data used so far is
+// kept.
+func disableUsage() error {
+	err := removeUnit(usageTimer)
+	if err != nil {
+		return err
+	}
+	err = removeFile(filepath.Join(systemdBase, usageService))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return reloadSystemd()
+}
+
+//UsageCMD reports the cellular data used, samples it or turns sampling on and
+//off.
+func UsageCMD(ctx *cli.Context) error {
+	switch {
+	case ctx.IsSet(sampleFlag):
+		return sampleUsage(time.Now())
+	case ctx.IsSet(enableFlag):
+		return enableUsage()
+	case ctx.IsSet(disableFlag):
+		return disableUsage()
+	}
+	return usageReport(time.Now())
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUsageAdd(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	s := &UsageState{}
+	c := func(iface string, index int, boot string, rx, tx uint64) counters {
+		return counters{Usage{RX: rx, TX: tx}, iface, index, boot}
+	}
+	s.add(c("ppp0", 7, "boot1", 100, 10), now)
+	s.add(c("ppp0", 7, "boot1", 250, 30), now.Add(time.Minute))
+	// the modem redialed, the counters started over.
+	s.add(c("ppp0", 7, "boot1", 50, 5), now.Add(2*time.Minute))
+	// the modem redialed again and passed the last sample before it was
+	// sampled, the new interface has a new index.
+	s.add(c("ppp0", 8, "boot1", 80, 9), now.Add(3*time.Minute))
+	// the box rebooted, ppp0 may get the same index.
+	s.add(c("ppp0", 8, "boot2", 90, 10), now.Add(4*time.Minute))
+	// the next day on another interface.
+	s.add(c("ppp1", 9, "boot2", 1000, 100), now.Add(24*time.Hour))
+	e := map[string]*Usage{
+		"2026-10-19": {RX: 470, TX: 54},
+		"2026-10-20": {RX: 1000, TX: 100},
+	}
+	if !reflect.DeepEqual(s.Days, e) {
+		t.Errorf("expected %v got %v", e, s.Days)
+	}
+	if s.Interface != "ppp1" || s.IfIndex != 9 || s.BootID != "boot2" ||
+		s.Last != (Usage{RX: 1000, TX: 100}) {
+		t.Errorf("unexpected last sample %s %d %s %v", s.Interface, s.IfIndex, s.BootID, s.Last)
+	}
+
+	// old days are dropped.
+	s.add(c("ppp1", 9, "boot2", 1000, 100), now.AddDate(0, 0, usageDays+1))
+	if _, ok := s.Days["2026-10-19"]; ok {
+		t.Error("expected old days to be dropped")
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	sample := []struct {
+		now      string
+		resetDay int
+		start    string
+	}{
+		{"2026-10-19", 0, "2026-10-01"},
+		{"2026-10-19", 20, "2026-09-20"},
+		{"2026-10-20", 20, "2026-10-20"},
+		{"2026-01-05", 15, "2025-12-15"},
+		{"2026-02-28", 31, "2026-02-28"},
+		{"2026-02-27", 31, "2026-01-31"},
+	}
+	for _, v := range sample {
+		now, _ := time.Parse(dayLayout, v.now)
+		now = now.Add(13 * time.Hour)
+		s := periodStart(now, v.resetDay).Format(dayLayout)
+		if s != v.start {
+			t.Errorf("%s reset on %d: expected %s got %s", v.now, v.resetDay, v.start, s)
+		}
+	}
+}
+
+func TestDataCap(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	s := &UsageState{Days: map[string]*Usage{
+		"2026-09-30": {RX: 900 * mb},
+		"2026-10-18": {RX: 150 * mb, TX: 50 * mb},
+		"2026-10-19": {RX: 40 * mb, TX: 10 * mb},
+	}}
+	sample := []struct {
+		cap     *DataCap
+		enabled bool
+		capped  bool
+		disable bool
+		enable  bool
+	}{
+		{nil, true, false, false, false},
+		{&DataCap{DailyMB: 50}, true, false, true, false},
+		{&DataCap{DailyMB: 100}, true, false, false, false},
+		{&DataCap{MonthlyMB: 250}, true, false, true, false},
+		{&DataCap{MonthlyMB: 300}, true, false, false, false},
+		// the bundle renewed on the 1st, the september data does not count.
+		{&DataCap{MonthlyMB: 300}, false, true, false, true},
+		{&DataCap{MonthlyMB: 1000, ResetDay: 25}, true, false, true, false},
+		// disabled by hand, fconf leaves it alone.
+		{&DataCap{DailyMB: 100}, false, false, false, false},
+	}
+	for _, v := range sample {
+		s.Capped = v.capped
+		d, e := s.capAction(v.cap, v.enabled, now)
+		if d != v.disable || e != v.enable {
+			t.Errorf("%+v: expected disable %v enable %v got %v %v",
+				v.cap, v.disable, v.enable, d, e)
+		}
+	}
+
+	r := s.report(&DataCap{ResetDay: 1}, now)
+	daily := map[string]Usage{
+		"2026-10-18": {RX: 150 * mb, TX: 50 * mb},
+		"2026-10-19": {RX: 40 * mb, TX: 10 * mb},
+	}
+	if !reflect.DeepEqual(r.Daily, daily) {
+		t.Errorf("expected %v got %v", daily, r.Daily)
+	}
+	monthly := map[string]Usage{
+		"2026-09-01": {RX: 900 * mb},
+		"2026-10-01": {RX: 190 * mb, TX: 60 * mb},
+	}
+	if !reflect.DeepEqual(r.Monthly, monthly) {
+		t.Errorf("expected %v got %v", monthly, r.Monthly)
+	}
+}
+
+func TestSampleUsage(t *testing.T) {
+	dir := testStateDir(t)
+	orig := sysClassNet
+	sysClassNet = filepath.Join(dir, "net")
+	defer func() {
+		sysClassNet = orig
+	}()
+
+	err := ioutil.WriteFile(filepath.Join(dir, "4g-ndis@wwan0.json"),
+		[]byte(`{"enabled":true,"config":{"interface":"wwan0"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := filepath.Join(sysClassNet, "wwan0", "statistics")
+	err = os.MkdirAll(stats, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(sysClassNet, "wwan0", "ifindex"), []byte("4\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for k, v := range []Usage{{RX: 1000, TX: 200}, {RX: 5000, TX: 700}} {
+		for name, n := range map[string]string{
+			"rx_bytes": strconv.FormatUint(v.RX, 10), "tx_bytes": strconv.FormatUint(v.TX, 10),
+		} {
+			err = ioutil.WriteFile(filepath.Join(stats, name), []byte(n+"\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = sampleUsage(now.Add(time.Duration(k) * time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := usageState("4g-ndis-wwan0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := s.Days["2026-10-19"]; u == nil || *u != (Usage{RX: 5000, TX: 700}) {
+		t.Errorf("unexpected usage %v", u)
+	}
+}
+
+func TestUplinkContext(t *testing.T) {
+	ctx := uplinkContext("639020000000001")
+	if i := getInterface(ctx); i != "639020000000001" {
+		t.Errorf("expected 639020000000001 got %s", i)
+	}
+	if ctx.IsSet(configFlag) {
+		t.Error("expected no config flag")
+	}
+}
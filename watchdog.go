@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/urfave/cli"
+)
+
+const (
+	watchdogState   = "watchdog.json"
+	watchdogService = "fconf-watchdog.service"
+
+	// Watchdog checks and actions.
+	CheckPing        = "ping"
+	CheckDNS         = "dns"
+	ActionRestart    = "restart"
+	ActionPowerCycle = "power-cycle"
+	ActionFailover   = "failover"
+
+	// the default targets of the checks, the dns check needs a name because
+	// the resolver answers ip addresses without asking the server.
+	defaultPingTarget = "8.8.8.8"
+	defaultDNSTarget  = "google.com"
+)
+
+//Watchdog checks the connectivity of the enabled uplinks. After Failures
+//failed checks in a row it takes the next of Actions, so that a dead uplink is
+//restarted first, then power cycled and at last replaced by the next uplink.
+//
+//Uplinks are the uplinks to watch in failover order, named like
+//3g-ras-<imsi> or 4g-ndis-<interface>, all configured uplinks are watched
+//when it is empty.
+type Watchdog struct {
+	Check    string   `json:"check"`
+	Target   string   `json:"target"`
+	Resolver string   `json:"resolver,omitempty"`
+	Interval int      `json:"interval"`
+	Timeout  int      `json:"timeout"`
+	Failures int      `json:"failures"`
+	Actions  []string `json:"actions"`
+	Uplinks  []string `json:"uplinks,omitempty"`
+}
+
+// defaultWatchdog pings a public DNS server every minute, and goes through all
+// actions after three failures. The target is set by setTarget once the check
+// is known.
+func defaultWatchdog() *Watchdog {
+	return &Watchdog{
+		Check:    CheckPing,
+		Resolver: "8.8.8.8:53",
+		Interval: 60,
+		Timeout:  5,
+		Failures: 3,
+		Actions:  []string{ActionRestart, ActionPowerCycle, ActionFailover},
+	}
+}
+
+// setTarget sets the default target of the check when there is none.
+func (w *Watchdog) setTarget() {
+	if w.Target != "" {
+		return
+	}
+	if w.Check == CheckDNS {
+		w.Target = defaultDNSTarget
+		return
+	}
+	w.Target = defaultPingTarget
+}
+
+func (w *Watchdog) validate() error {
+	switch w.Check {
+	case CheckPing, CheckDNS:
+	default:
+		return fmt.Errorf("fconf: unknown watchdog check %s, use ping or dns", w.Check)
+	}
+	if w.Target == "" {
+		return errors.New("fconf: missing watchdog target")
+	}
+	if w.Check == CheckDNS {
+		if net.ParseIP(w.Target) != nil {
+			return errors.New("fconf: the dns watchdog target must be a host name")
+		}
+		_, _, err := net.SplitHostPort(w.Resolver)
+		if err != nil {
+			return fmt.Errorf("fconf: bad watchdog resolver %v", err)
+		}
+	}
+	if w.Interval < 1 || w.Timeout < 1 || w.Failures < 1 {
+		return errors.New("fconf: watchdog interval, timeout and failures must be positive")
+	}
+	if len(w.Actions) == 0 {
+		return errors.New("fconf: missing watchdog actions")
+	}
+	for _, a := range w.Actions {
+		switch a {
+		case ActionRestart, ActionPowerCycle, ActionFailover:
+		default:
+			return fmt.Errorf("fconf: unknown watchdog action %s", a)
+		}
+	}
+	return nil
+}
+
+// pingCheck pings target through the interface.
+func pingCheck(target, iface string, timeout time.Duration) error {
+	o, err := exec.Command("ping", "-c", "1", "-W", strconv.Itoa(int(timeout/time.Second)),
+		"-I", iface, target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ping %s %v %s", target, err, o)
+	}
+	return nil
+}
+
+// dnsCheck resolves name with the DNS server resolver through the interface.
+func dnsCheck(name, resolver, iface string, timeout time.Duration) error {
+	d := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET,
+					syscall.SO_BINDTODEVICE, iface)
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return d.DialContext(ctx, "udp", resolver)
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := r.LookupHost(ctx, name)
+	return err
+}
+
+// watchdog is the state of a running Watchdog. check and act are fields so
+// tests can replace the network and systemd.
+type watchdog struct {
+	*Watchdog
+	failures map[string]int
+	next     map[string]int
+	check    func(u *uplink) error
+	act      func(action string, u *uplink, ups []*uplink) error
+}
+
+func newWatchdog(w *Watchdog) *watchdog {
+	d := &watchdog{
+		Watchdog: w,
+		failures: make(map[string]int),
+		next:     make(map[string]int),
+	}
+	d.check = d.checkUplink
+	d.act = d.apply
+	return d
+}
+
+func (w *watchdog) checkUplink(u *uplink) error {
+	iface, err := u.iface()
+	if err != nil {
+		return err
+	}
+	timeout := time.Duration(w.Timeout) * time.Second
+	if w.Check == CheckDNS {
+		return dnsCheck(w.Target, w.Resolver, iface, timeout)
+	}
+	return pingCheck(w.Target, iface, timeout)
+}
+
+// watched returns the uplinks to watch in failover order.
+func (w *watchdog) watched(ups []*uplink) []*uplink {
+	if len(w.Uplinks) == 0 {
+		return ups
+	}
+	byName := make(map[string]*uplink)
+	for _, u := range ups {
+		byName[u.name()] = u
+	}
+	var result []*uplink
+	for _, n := range w.Uplinks {
+		if u, ok := byName[n]; ok {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// step checks the enabled uplinks once and acts on the ones that failed too
+// many times.
+func (w *watchdog) step(ups []*uplink) {
+	ups = w.watched(ups)
+	for _, u := range ups {
+		n := u.name()
+		if !u.Enabled {
+			delete(w.failures, n)
+			delete(w.next, n)
+			continue
+		}
+		err := w.check(u)
+		if err == nil {
+			if w.failures[n] > 0 || w.next[n] > 0 {
+				log.Printf("watchdog: %s is back", n)
+			}
+			delete(w.failures, n)
+			delete(w.next, n)
+			continue
+		}
+		w.failures[n]++
+		log.Printf("watchdog: %s check %d/%d failed %v", n, w.failures[n], w.Failures, err)
+		if w.failures[n] < w.Failures {
+			continue
+		}
+		k := w.next[n]
+		if k >= len(w.Actions) {
+			k = len(w.Actions) - 1
+		}
+		a := w.Actions[k]
+		log.Printf("watchdog: %s %s", a, n)
+		err = w.act(a, u, ups)
+		if err != nil {
+			log.Printf("watchdog: %s %s failed %v", a, n, err)
+		}
+		w.failures[n] = 0
+		w.next[n] = k + 1
+	}
+}
+
+// apply takes the action on the uplink u. The uplinks are read again under
+// the uplinks lock, fconf usage --sample may have changed them since the
+// check.
+func (w *watchdog) apply(action string, u *uplink, ups []*uplink) error {
+	l, err := lockUplinks()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	all, err := uplinks()
+	if err != nil {
+		return err
+	}
+	ups = w.watched(all)
+	n := u.name()
+	u = nil
+	for _, v := range ups {
+		if v.name() == n {
+			u = v
+		}
+	}
+	if u == nil || !u.Enabled {
+		log.Printf("watchdog: %s was disabled meanwhile", n)
+		return nil
+	}
+	switch action {
+	case ActionRestart:
+		return u.restart()
+	case ActionPowerCycle:
+		err = u.disable()
+		if err != nil {
+			return err
+		}
+		return u.enable()
+	case ActionFailover:
+		f := failoverUplink(u, ups)
+		if f == nil {
+			return errors.New("no uplink to fail over to")
+		}
+		log.Printf("watchdog: failing over from %s to %s", u.name(), f.name())
+		err = u.disable()
+		if err != nil {
+			return err
+		}
+		return f.enable()
+	}
+	return fmt.Errorf("unknown action %s", action)
+}
+
+// failoverUplink returns the next uplink after u which is not enabled and did
+// not use up its data.
+func failoverUplink(u *uplink, ups []*uplink) *uplink {
+	k := 0
+	for ; k < len(ups); k++ {
+		if ups[k] == u {
+			break
+		}
+	}
+	for i := 1; i < len(ups); i++ {
+		f := ups[(k+i)%len(ups)]
+		if f.Enabled {
+			continue
+		}
+		s, err := usageState(f.name())
+		if err == nil && s.Capped {
+			continue
+		}
+		return f
+	}
+	return nil
+}
+
+// loadWatchdog returns the saved watchdog configuration, or the default one.
+func loadWatchdog() (*Watchdog, error) {
+	w := defaultWatchdog()
+	b, err := ioutil.ReadFile(filepath.Join(stateDir(), watchdogState))
+	if err != nil {
+		if os.IsNotExist(err) {
+			w.setTarget()
+			return w, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, w)
+	if err != nil {
+		return nil, err
+	}
+	w.setTarget()
+	return w, nil
+}
+
+// runWatchdog checks the uplinks forever.
+func runWatchdog(w *Watchdog) error {
+	d := newWatchdog(w)
+	log.Printf("watchdog: checking with %s %s every %ds", w.Check, w.Target, w.Interval)
+	for {
+		ups, err := uplinks()
+		if err != nil {
+			log.Printf("watchdog: %v", err)
+		} else {
+			d.step(ups)
+		}
+		time.Sleep(time.Duration(w.Interval) * time.Second)
+	}
+}
+
+// watchdogUnit runs the watchdog.
+type watchdogUnit struct {
+	bin string
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (w watchdogUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf uplink watchdog"),
+		unit.NewUnitOption("Unit", "After", "network.target"),
+		unit.NewUnitOption("Service", "ExecStart", w.bin+" watchdog --"+runFlag),
+		unit.NewUnitOption("Service", "Restart", "always"),
+		unit.NewUnitOption("Service", "RestartSec", "10"),
+		unit.NewUnitOption("Install", "WantedBy", "multi-user.target"),
+	}, nil
+}
+
+// configWatchdog saves the watchdog configuration read from src, fields
+// missing in src keep their default.
+func configWatchdog(src string) error {
+	var b []byte
+	var err error
+	if src == "stdin" {
+		b, err = ReadFromStdin()
+	} else {
+		b, err = ioutil.ReadFile(src)
+	}
+	if err != nil {
+		return err
+	}
+	w := defaultWatchdog()
+	err = json.Unmarshal(b, w)
+	if err != nil {
+		return err
+	}
+	w.setTarget()
+	err = w.validate()
+	if err != nil {
+		return err
+	}
+	b, err = json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return keepState(watchdogState, b)
+}
+
+//WatchdogCMD configures, runs, enables or disables the uplink watchdog.
+func WatchdogCMD(ctx *cli.Context) error {
+	if ctx.IsSet(configFlag) {
+		err := configWatchdog(ctx.String(configFlag))
+		if err != nil {
+			return err
+		}
+		if !ctx.IsSet(enableFlag) {
+			return nil
+		}
+	}
+	switch {
+	case ctx.IsSet(runFlag):
+		w, err := loadWatchdog()
+		if err != nil {
+			return err
+		}
+		err = w.validate()
+		if err != nil {
+			return err
+		}
+		return runWatchdog(w)
+	case ctx.IsSet(enableFlag):
+		err := CreateSystemdFile(watchdogUnit{fconfBin()},
+			filepath.Join(systemdBase, watchdogService), 0644)
+		if err != nil {
+			return err
+		}
+		err = reloadSystemd()
+		if err != nil {
+			return err
+		}
+		err = restartService(watchdogService)
+		if err != nil {
+			return err
+		}
+		return enableService(watchdogService)
+	case ctx.IsSet(disableFlag):
+		err := removeUnit(watchdogService)
+		if err != nil {
+			return err
+		}
+		return reloadSystemd()
+	}
+	w, err := loadWatchdog()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
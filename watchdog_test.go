@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchdogValidate(t *testing.T) {
+	w := defaultWatchdog()
+	w.setTarget()
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+	w = &Watchdog{Check: CheckDNS}
+	w.setTarget()
+	if w.Target != defaultDNSTarget {
+		t.Errorf("expected %s got %s", defaultDNSTarget, w.Target)
+	}
+	sample := []func(w *Watchdog){
+		func(w *Watchdog) { w.Check = "http" },
+		func(w *Watchdog) { w.Target = "" },
+		func(w *Watchdog) { w.Check = CheckDNS; w.Resolver = "8.8.8.8" },
+		func(w *Watchdog) { w.Check = CheckDNS; w.Target = "8.8.8.8" },
+		func(w *Watchdog) { w.Interval = 0 },
+		func(w *Watchdog) { w.Failures = -1 },
+		func(w *Watchdog) { w.Actions = nil },
+		func(w *Watchdog) { w.Actions = []string{ActionRestart, "reboot"} },
+	}
+	for i, f := range sample {
+		w := defaultWatchdog()
+		w.setTarget()
+		f(w)
+		if err := w.validate(); err == nil {
+			t.Errorf("%d: expected an error", i)
+		}
+	}
+}
+
+func TestWatchdogStep(t *testing.T) {
+	a := &uplink{cmd: "3g-ras", key: "a", Enabled: true, threeG: &ThreeG{}}
+	b := &uplink{cmd: "3g-ras", key: "b", threeG: &ThreeG{}}
+	w := defaultWatchdog()
+	w.Failures = 2
+	d := newWatchdog(w)
+	up := false
+	var acts []string
+	d.check = func(u *uplink) error {
+		if up {
+			return nil
+		}
+		return errors.New("down")
+	}
+	d.act = func(action string, u *uplink, ups []*uplink) error {
+		acts = append(acts, action+" "+u.name())
+		return nil
+	}
+	for i := 0; i < 8; i++ {
+		d.step([]*uplink{a, b})
+	}
+	e := []string{
+		"restart 3g-ras-a",
+		"power-cycle 3g-ras-a",
+		"failover 3g-ras-a",
+		"failover 3g-ras-a",
+	}
+	if !reflect.DeepEqual(acts, e) {
+		t.Errorf("expected %v got %v", e, acts)
+	}
+
+	// a successful check starts over with the first action.
+	up = true
+	d.step([]*uplink{a, b})
+	up = false
+	acts = nil
+	for i := 0; i < 2; i++ {
+		d.step([]*uplink{a, b})
+	}
+	e = []string{"restart 3g-ras-a"}
+	if !reflect.DeepEqual(acts, e) {
+		t.Errorf("expected %v got %v", e, acts)
+	}
+
+	// only the configured uplinks are watched.
+	w.Uplinks = []string{"3g-ras-b"}
+	acts = nil
+	for i := 0; i < 4; i++ {
+		d.step([]*uplink{a, b})
+	}
+	if len(acts) != 0 {
+		t.Errorf("expected no actions got %v", acts)
+	}
+}
+
+func TestFailoverUplink(t *testing.T) {
+	testStateDir(t)
+
+	a := &uplink{cmd: "3g-ras", key: "a", Enabled: true}
+	b := &uplink{cmd: "3g-ras", key: "b"}
+	c := &uplink{cmd: "4g-ndis", key: "c", Enabled: true}
+	d := &uplink{cmd: "4g-ndis", key: "d"}
+	ups := []*uplink{a, b, c, d}
+	if f := failoverUplink(c, ups); f != d {
+		t.Errorf("expected %v got %v", d, f)
+	}
+	if f := failoverUplink(a, ups); f != b {
+		t.Errorf("expected %v got %v", b, f)
+	}
+
+	// b used all its data.
+	err := keepState("usage@3g-ras-b.json", []byte(`{"capped":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := failoverUplink(a, ups); f != d {
+		t.Errorf("expected %v got %v", d, f)
+	}
+	d.Enabled = true
+	if f := failoverUplink(a, ups); f != nil {
+		t.Errorf("expected no uplink got %v", f)
+	}
+}
+
+func TestDNSCheck(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go servePortalDNS(conn, net.ParseIP("127.0.0.1"))
+
+	err = dnsCheck("example.com", conn.LocalAddr().String(), "lo", time.Second)
+	if os.IsPermission(err) || errors.Is(err, os.ErrPermission) {
+		t.Skip("binding to an interface needs CAP_NET_RAW")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dnsCheck("example.com", "127.0.0.1:1", "lo", time.Second)
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -21,6 +22,12 @@ type WifiState struct {
 }
 
 func WifiClientCMD(ctx *cli.Context) error {
+	if ctx.IsSet(scanFlag) {
+		return ScanWifi(ctx)
+	}
+	if ctx.IsSet(statusFlag) {
+		return WifiStatusCMD(ctx)
+	}
 	if ctx.IsSet(enableFlag) {
 		return EnableWifiClient(ctx)
 	}
@@ -30,6 +37,12 @@ func WifiClientCMD(ctx *cli.Context) error {
 	if ctx.IsSet(removeFlag) {
 		return RemoveWifi(ctx)
 	}
+	if ctx.IsSet(addNetworkFlag) {
+		return AddWifiNetwork(ctx)
+	}
+	if ctx.IsSet(removeNetworkFlag) {
+		return RemoveWifiNetwork(ctx)
+	}
 	if ctx.IsSet(configFlag) {
 		return configWifiClient(ctx)
 	}
@@ -137,6 +150,15 @@ func configWifiClient(ctx *cli.Context) error {
 	if e.Interface == "" {
 		e.Interface = "wlan0"
 	}
+	err = copyWifiCerts(wpaCertDir(e.Interface), e.SavedNetworks())
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = wifiConfig(&e, &buf)
+	if err != nil {
+		return err
+	}
 	err = checkDir(base)
 	if err != nil {
 		return err
@@ -150,17 +172,12 @@ func configWifiClient(ctx *cli.Context) error {
 		return err
 	}
 	fmt.Printf("successful written wifi configuration to %s \n", filename)
-	path := "/etc/wpa_supplicant/"
-	err = checkDir(path)
+	err = checkDir(wpaSupplicantDir)
 	if err != nil {
 		return err
 	}
-	cname := "wpa_supplicant-" + e.Interface + ".conf"
-	s, err := wifiConfig(e.Username, e.Password)
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(filepath.Join(path, cname), []byte(s), 0644)
+	cname := wpaSupplicantFile(e.Interface)
+	err = ioutil.WriteFile(cname, buf.Bytes(), 0600)
 	if err != nil {
 		return err
 	}
@@ -170,20 +187,254 @@ func configWifiClient(ctx *cli.Context) error {
 		state.Enabled = ws.Enabled
 	}
 	b, _ = json.Marshal(state)
-	fmt.Printf("successful written wifi connection  configuration to %s \n", filepath.Join(path, cname))
+	fmt.Printf("successful written wifi connection  configuration to %s \n", cname)
 	setInterface(ctx, e.Interface)
 	return keepState(
 		fmt.Sprintf(defaultWifiClientConfig, e.Interface), b)
 }
 
-func wifiConfig(username, password string) (string, error) {
-	cmd := "/usr/bin/wpa_passphrase"
-	firstLine := "ctrl_interface=/run/wpa_supplicant_fconf"
-	o, err := exec.Command(cmd, username, password).Output()
+func wpaSupplicantFile(i string) string {
+	return filepath.Join(wpaSupplicantDir, "wpa_supplicant-"+i+".conf")
+}
+
+//ScanWifi prints a json array of the wifi networks in range of the
+//interface. This talks to the wpa_supplicant instance started by fconf.
+func ScanWifi(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	c, err := DialWPACtrl(wpaCtrlInterface, i)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	r, err := c.Scan()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+//WifiStatusCMD prints the live state of the wifi client as json. Unlike
+//WifiState this reports whether the interface is actually associated.
+func WifiStatusCMD(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	c, err := DialWPACtrl(wpaCtrlInterface, i)
 	if err != nil {
-		return "", err
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	s, err := c.Status()
+	if err != nil {
+		return err
+	}
+	s.Interface = i
+	if s.IPAddress == "" {
+		s.IPAddress = interfaceIPv4(i)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// wpaCertDir is the directory where fconf keeps copies of eap certificates
+// for interface i.
+func wpaCertDir(i string) string {
+	return filepath.Join(wpaSupplicantDir, "fconf-certs", i)
+}
+
+// copyWifiCerts copies eap certificates and keys of networks into dir, and
+// updates the networks to point to the copies. This way the configuration
+// keeps working after the original files are gone.
+func copyWifiCerts(dir string, networks []*WifiNetwork) error {
+	for _, n := range networks {
+		if n.EAP == nil {
+			continue
+		}
+		for _, v := range []struct {
+			kind string
+			path *string
+		}{
+			{"ca", &n.EAP.CACert},
+			{"client", &n.EAP.ClientCert},
+			{"key", &n.EAP.PrivateKey},
+		} {
+			if *v.path == "" {
+				continue
+			}
+			name := filepath.Join(dir,
+				certName(n.SSID)+"-"+v.kind+filepath.Ext(*v.path))
+			if name == *v.path {
+				continue
+			}
+			b, err := ioutil.ReadFile(*v.path)
+			if err != nil {
+				return err
+			}
+			err = checkDir(dir)
+			if err != nil {
+				return err
+			}
+			err = ioutil.WriteFile(name, b, 0600)
+			if err != nil {
+				return err
+			}
+			*v.path = name
+		}
 	}
-	return fmt.Sprintf("%s \n \n%s\n", firstLine, string(o)), nil
+	return nil
+}
+
+// certName turns ssid into something safe to use as a file name.
+func certName(ssid string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, ssid)
+}
+
+//AddWifiNetwork adds a network to the saved networks of an already configured
+//wifi client. The network is read as json from the path given by the
+//add-network flag, or from stdin.
+func AddWifiNetwork(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	src := ctx.String(addNetworkFlag)
+	var b []byte
+	var err error
+	if src == "stdin" {
+		b, err = ReadFromStdin()
+		if err != nil {
+			return err
+		}
+	} else {
+		b, err = ioutil.ReadFile(src)
+		if err != nil {
+			return err
+		}
+	}
+	n := &WifiNetwork{}
+	err = json.Unmarshal(b, n)
+	if err != nil {
+		return err
+	}
+	if n.SSID == "" {
+		return errors.New("fconf: missing ssid")
+	}
+	w, err := wifiClientState(i)
+	if err != nil {
+		return err
+	}
+	err = copyWifiCerts(wpaCertDir(i), []*WifiNetwork{n})
+	if err != nil {
+		return err
+	}
+	w.Configg.AddNetwork(n)
+	err = updateWifiNetworks(w)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("successful added wifi network %s to %s\n", n.SSID, i)
+	return nil
+}
+
+//RemoveWifiNetwork removes the network with the ssid given by the
+//remove-network flag from the saved networks.
+func RemoveWifiNetwork(ctx *cli.Context) error {
+	i := getInterface(ctx)
+	if i == "" {
+		return errors.New("missing interface, you must specify interface")
+	}
+	ssid := ctx.String(removeNetworkFlag)
+	w, err := wifiClientState(i)
+	if err != nil {
+		return err
+	}
+	if !w.Configg.RemoveNetwork(ssid) {
+		return fmt.Errorf("fconf: no wifi network with ssid %s", ssid)
+	}
+	err = updateWifiNetworks(w)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("successful removed wifi network %s from %s\n", ssid, i)
+	return nil
+}
+
+// updateWifiNetworks rewrites wpa_supplicant configuration after the saved
+// networks of w have changed. wpa_supplicant is restarted when the wifi
+// client is enabled, so the change takes effect immediately.
+func updateWifiNetworks(w *WifiState) error {
+	var buf bytes.Buffer
+	err := wifiConfig(w.Configg, &buf)
+	if err != nil {
+		return err
+	}
+	err = checkDir(wpaSupplicantDir)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(wpaSupplicantFile(w.Configg.Interface), buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	if w.Enabled {
+		err = restartService("wpa_supplicant@" + w.Configg.Interface)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return keepState(
+		fmt.Sprintf(defaultWifiClientConfig, w.Configg.Interface), data)
+}
+
+//wifiConfig writes wpa_supplicant configuration for w to dst.
+func wifiConfig(w *Wifi, dst io.Writer) error {
+	c := &WPASupplicant{
+		CtrlInterface: wpaCtrlInterface,
+		Country:       strings.ToUpper(w.Country),
+	}
+	if c.Country != "" && !isCountryCode(c.Country) {
+		return errors.New("fconf: country must be a two letter ISO 3166-1 code")
+	}
+	for _, v := range w.SavedNetworks() {
+		n, err := NewWPANetwork(v)
+		if err != nil {
+			return err
+		}
+		c.Networks = append(c.Networks, n)
+	}
+	if len(c.Networks) == 0 {
+		return errors.New("fconf: no wifi network configured")
+	}
+	_, err := c.WriteTo(dst)
+	return err
 }
 
 func DisableWifi(ctx *cli.Context) error {
@@ -249,16 +500,17 @@ func RemoveWifi(ctx *cli.Context) error {
 		}
 	}
 
-	path := "/etc/wpa_supplicant/"
-	cname := "wpa_supplicant-" + w.Configg.Interface + ".conf"
-
 	// remove client connection
-	err = removeFile(filepath.Join(path, cname))
+	err = removeFile(wpaSupplicantFile(w.Configg.Interface))
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return err
 		}
 	}
+	err = os.RemoveAll(wpaCertDir(w.Configg.Interface))
+	if err != nil {
+		return err
+	}
 
 	// remove the state file
 	stateFile := filepath.Join(stateDir(),
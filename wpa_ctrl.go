@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var wpaCtrlCounter int32
+
+//WPACtrl is a client for wpa_supplicant control interface.
+type WPACtrl struct {
+	conn    *net.UnixConn
+	local   string
+	Timeout time.Duration
+}
+
+//ScanResult is a network found by wpa_supplicant scan.
+type ScanResult struct {
+	SSID      string   `json:"ssid"`
+	BSSID     string   `json:"bssid"`
+	Frequency int      `json:"frequency"`
+	Signal    int      `json:"signal"`
+	Flags     []string `json:"flags"`
+	Security  string   `json:"security"`
+}
+
+//WifiStatus is the state of a wifi client as reported by wpa_supplicant.
+type WifiStatus struct {
+	Interface string `json:"interface"`
+	WPAState  string `json:"wpa_state"`
+	SSID      string `json:"ssid,omitempty"`
+	BSSID     string `json:"bssid,omitempty"`
+	Frequency int    `json:"frequency,omitempty"`
+	RSSI      int    `json:"rssi,omitempty"`
+	LinkSpeed int    `json:"link_speed,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+	KeyMgmt   string `json:"key_mgmt,omitempty"`
+}
+
+//DialWPACtrl connects to the control socket of interface i in the dir
+//directory. dir is the ctrl_interface set in wpa_supplicant configuration.
+func DialWPACtrl(dir, i string) (*WPACtrl, error) {
+	local := filepath.Join(os.TempDir(), fmt.Sprintf("fconf_wpa_ctrl_%d-%d",
+		os.Getpid(), atomic.AddInt32(&wpaCtrlCounter, 1)))
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: local, Net: "unixgram"},
+		&net.UnixAddr{Name: filepath.Join(dir, i), Net: "unixgram"},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &WPACtrl{conn: conn, local: local, Timeout: 10 * time.Second}, nil
+}
+
+//Close closes the connection and removes the local socket.
+func (w *WPACtrl) Close() error {
+	err := w.conn.Close()
+	_ = os.Remove(w.local)
+	return err
+}
+
+//Request sends cmd to wpa_supplicant and returns the reply. Unsolicited event
+//messages received while waiting for the reply are dropped.
+func (w *WPACtrl) Request(cmd string) (string, error) {
+	_, err := w.conn.Write([]byte(cmd))
+	if err != nil {
+		return "", err
+	}
+	for {
+		m, err := w.read()
+		if err != nil {
+			return "", err
+		}
+		if isWPAEvent(m) {
+			continue
+		}
+		return m, nil
+	}
+}
+
+func (w *WPACtrl) read() (string, error) {
+	err := w.conn.SetReadDeadline(time.Now().Add(w.Timeout))
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 8192)
+	n, err := w.conn.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+// waitEvent waits for an event message that contains name. The connection
+// must be attached.
+func (w *WPACtrl) waitEvent(name string) error {
+	for {
+		m, err := w.read()
+		if err != nil {
+			return err
+		}
+		if isWPAEvent(m) && strings.Contains(m, name) {
+			return nil
+		}
+	}
+}
+
+func isWPAEvent(m string) bool {
+	return strings.HasPrefix(m, "<")
+}
+
+func (w *WPACtrl) ok(cmd string) error {
+	r, err := w.Request(cmd)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(r) != "OK" {
+		return fmt.Errorf("fconf: %s failed: %s", cmd, strings.TrimSpace(r))
+	}
+	return nil
+}
+
+//Scan triggers a scan and returns the networks found.
+func (w *WPACtrl) Scan() ([]*ScanResult, error) {
+	err := w.ok("ATTACH")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = w.Request("DETACH")
+	}()
+	r, err := w.Request("SCAN")
+	if err != nil {
+		return nil, err
+	}
+	// FAIL-BUSY means a scan is already running, we just wait for it.
+	r = strings.TrimSpace(r)
+	if r != "OK" && r != "FAIL-BUSY" {
+		return nil, fmt.Errorf("fconf: SCAN failed: %s", r)
+	}
+	err = w.waitEvent("CTRL-EVENT-SCAN-RESULTS")
+	if err != nil {
+		return nil, err
+	}
+	return w.ScanResults()
+}
+
+//ScanResults returns the results of the last scan.
+func (w *WPACtrl) ScanResults() ([]*ScanResult, error) {
+	r, err := w.Request("SCAN_RESULTS")
+	if err != nil {
+		return nil, err
+	}
+	return parseScanResults(r)
+}
+
+//Status returns the current state of the wifi client. Signal information is
+//only available when the client is associated.
+func (w *WPACtrl) Status() (*WifiStatus, error) {
+	r, err := w.Request("STATUS")
+	if err != nil {
+		return nil, err
+	}
+	kv := parseWPAKeyValue(r)
+	s := &WifiStatus{
+		WPAState:  kv["wpa_state"],
+		SSID:      wpaUnescape(kv["ssid"]),
+		BSSID:     kv["bssid"],
+		IPAddress: kv["ip_address"],
+		KeyMgmt:   kv["key_mgmt"],
+	}
+	if s.WPAState == "" {
+		return nil, fmt.Errorf("fconf: bad STATUS reply: %s", strings.TrimSpace(r))
+	}
+	s.Frequency, _ = strconv.Atoi(kv["freq"])
+	if s.WPAState != "COMPLETED" {
+		return s, nil
+	}
+	r, err = w.Request("SIGNAL_POLL")
+	if err != nil {
+		return nil, err
+	}
+	kv = parseWPAKeyValue(r)
+	s.RSSI, _ = strconv.Atoi(kv["RSSI"])
+	s.LinkSpeed, _ = strconv.Atoi(kv["LINKSPEED"])
+	if f, err := strconv.Atoi(kv["FREQUENCY"]); err == nil {
+		s.Frequency = f
+	}
+	return s, nil
+}
+
+func parseWPAKeyValue(src string) map[string]string {
+	m := make(map[string]string)
+	for _, l := range strings.Split(src, "\n") {
+		p := strings.SplitN(l, "=", 2)
+		if len(p) == 2 {
+			m[p[0]] = p[1]
+		}
+	}
+	return m
+}
+
+// parseScanResults parses the reply to SCAN_RESULTS, which looks like
+//
+//	bssid / frequency / signal level / flags / ssid
+//	00:11:22:33:44:55	2412	-45	[WPA2-PSK-CCMP][ESS]	voxbox
+func parseScanResults(src string) ([]*ScanResult, error) {
+	lines := strings.Split(strings.TrimSpace(src), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "bssid") {
+		return nil, errors.New("fconf: bad scan results")
+	}
+	result := []*ScanResult{}
+	for _, l := range lines[1:] {
+		f := strings.SplitN(l, "\t", 5)
+		if len(f) < 4 {
+			continue
+		}
+		s := &ScanResult{BSSID: f[0]}
+		s.Frequency, _ = strconv.Atoi(f[1])
+		s.Signal, _ = strconv.Atoi(f[2])
+		s.Flags = parseWPAFlags(f[3])
+		s.Security = securityFromFlags(s.Flags)
+		if len(f) == 5 {
+			s.SSID = wpaUnescape(f[4])
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func parseWPAFlags(s string) []string {
+	flags := []string{}
+	for _, v := range strings.Split(s, "]") {
+		v = strings.TrimPrefix(v, "[")
+		if v != "" {
+			flags = append(flags, v)
+		}
+	}
+	return flags
+}
+
+// securityFromFlags returns the security mode to use with a network based on
+// the flags reported by scan.
+func securityFromFlags(flags []string) string {
+	sec := SecurityOpen
+	for _, v := range flags {
+		switch {
+		case strings.Contains(v, "EAP"):
+			return SecurityWPAEAP
+		case strings.Contains(v, "PSK"):
+			sec = SecurityWPAPSK
+		case strings.Contains(v, "SAE") && sec == SecurityOpen:
+			sec = SecuritySAE
+		case v == "WEP":
+			// not supported by fconf, but the user should know it is not
+			// an open network.
+			sec = "wep"
+		}
+	}
+	return sec
+}
+
+// wpaUnescape decodes strings escaped by wpa_supplicant printf_encode.
+func wpaUnescape(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'e':
+			b = append(b, 033)
+		case 'x':
+			if i+2 < len(s) {
+				v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+				if err == nil {
+					b = append(b, byte(v))
+					i += 2
+					continue
+				}
+			}
+			b = append(b, '\\', 'x')
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return string(b)
+}
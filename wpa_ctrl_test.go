@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeWPASupplicant listens on dir/i and replies to control requests using
+// fn. Every string returned by fn is sent as a separate datagram.
+func fakeWPASupplicant(t *testing.T, dir, i string, fn func(cmd string) []string) func() {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{
+		Name: filepath.Join(dir, i), Net: "unixgram",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		b := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFromUnix(b)
+			if err != nil {
+				return
+			}
+			for _, v := range fn(string(b[:n])) {
+				_, _ = conn.WriteToUnix([]byte(v), addr)
+			}
+		}
+	}()
+	return func() {
+		_ = conn.Close()
+	}
+}
+
+const scanResults = "bssid / frequency / signal level / flags / ssid\n" +
+	"00:11:22:33:44:55\t2412\t-45\t[WPA2-PSK-CCMP][ESS]\tvoxbox\n" +
+	"00:11:22:33:44:56\t5180\t-70\t[WPA2-EAP-CCMP][ESS]\teduroam\n" +
+	"00:11:22:33:44:57\t2437\t-82\t[ESS]\tcaf\\xc3\\xa9 \\\"free\\\"\n" +
+	"00:11:22:33:44:58\t2462\t-60\t[WPA2-SAE-CCMP][ESS]\t\n"
+
+func TestWPACtrl_Scan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stop := fakeWPASupplicant(t, dir, "wlan0", func(cmd string) []string {
+		switch cmd {
+		case "ATTACH", "DETACH":
+			return []string{"OK\n"}
+		case "SCAN":
+			return []string{
+				"<3>CTRL-EVENT-SCAN-STARTED ",
+				"OK\n",
+				"<3>CTRL-EVENT-BSS-ADDED 0 00:11:22:33:44:55",
+				"<3>CTRL-EVENT-SCAN-RESULTS ",
+			}
+		case "SCAN_RESULTS":
+			return []string{scanResults}
+		}
+		return []string{"UNKNOWN COMMAND\n"}
+	})
+	defer stop()
+
+	c, err := DialWPACtrl(dir, "wlan0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r, err := c.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 4 {
+		t.Fatalf("expected 4 results got %d", len(r))
+	}
+	sample := []ScanResult{
+		{SSID: "voxbox", BSSID: "00:11:22:33:44:55", Frequency: 2412, Signal: -45, Security: SecurityWPAPSK},
+		{SSID: "eduroam", BSSID: "00:11:22:33:44:56", Frequency: 5180, Signal: -70, Security: SecurityWPAEAP},
+		{SSID: "café \"free\"", BSSID: "00:11:22:33:44:57", Frequency: 2437, Signal: -82, Security: SecurityOpen},
+		{SSID: "", BSSID: "00:11:22:33:44:58", Frequency: 2462, Signal: -60, Security: SecuritySAE},
+	}
+	for k, v := range sample {
+		g := r[k]
+		if g.SSID != v.SSID || g.BSSID != v.BSSID || g.Frequency != v.Frequency ||
+			g.Signal != v.Signal || g.Security != v.Security {
+			t.Errorf("expected %+v got %+v", v, *g)
+		}
+	}
+	if len(r[0].Flags) != 2 || r[0].Flags[0] != "WPA2-PSK-CCMP" {
+		t.Errorf("bad flags %v", r[0].Flags)
+	}
+}
+
+func TestWPACtrl_Status(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stop := fakeWPASupplicant(t, dir, "wlan0", func(cmd string) []string {
+		switch cmd {
+		case "STATUS":
+			return []string{"bssid=00:11:22:33:44:55\nfreq=2412\nssid=voxbox\n" +
+				"id=0\nmode=station\npairwise_cipher=CCMP\ngroup_cipher=CCMP\n" +
+				"key_mgmt=WPA2-PSK\nwpa_state=COMPLETED\nip_address=192.168.1.20\n" +
+				"address=b8:27:eb:00:00:01\n"}
+		case "SIGNAL_POLL":
+			return []string{"RSSI=-52\nLINKSPEED=65\nNOISE=9999\nFREQUENCY=2412\n"}
+		}
+		return []string{"UNKNOWN COMMAND\n"}
+	})
+	defer stop()
+
+	c, err := DialWPACtrl(dir, "wlan0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := WifiStatus{
+		WPAState:  "COMPLETED",
+		SSID:      "voxbox",
+		BSSID:     "00:11:22:33:44:55",
+		Frequency: 2412,
+		RSSI:      -52,
+		LinkSpeed: 65,
+		IPAddress: "192.168.1.20",
+		KeyMgmt:   "WPA2-PSK",
+	}
+	if *s != e {
+		t.Errorf("expected %+v got %+v", e, *s)
+	}
+}
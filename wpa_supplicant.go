@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/template"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const wpaCtrlInterface = "/run/wpa_supplicant_fconf"
+
+//WPASupplicant is the content of a wpa_supplicant configuration file.
+type WPASupplicant struct {
+	CtrlInterface string
+	Country       string
+	Networks      []*WPANetwork
+}
+
+//WPANetwork is a single network={} block in wpa_supplicant configuration.
+type WPANetwork struct {
+	SSID        string
+	KeyMgmt     string
+	PSK         string
+	SAEPassword string
+	IEEE80211W  int
+	Priority    int
+	ScanSSID    bool
+	BSSID       string
+	EAP         *WPAEAP
+}
+
+//WPAEAP is the 802.1X part of a network={} block.
+type WPAEAP struct {
+	Method             string
+	Identity           string
+	AnonymousIdentity  string
+	Password           string
+	Phase2             string
+	CACert             string
+	ClientCert         string
+	PrivateKey         string
+	PrivateKeyPassword string
+}
+
+var wpaTpl = template.Must(template.New("wpa").Funcs(template.FuncMap{
+	"str":   wpaString,
+	"quote": wpaQuote,
+}).Parse(`ctrl_interface={{.CtrlInterface}}
+{{- if .Country}}
+country={{.Country}}
+{{- end}}
+{{range .Networks}}
+network={
+	ssid={{str .SSID}}
+	{{- if .ScanSSID}}
+	scan_ssid=1
+	{{- end}}
+	{{- if .BSSID}}
+	bssid={{.BSSID}}
+	{{- end}}
+	{{- if .KeyMgmt}}
+	key_mgmt={{.KeyMgmt}}
+	{{- end}}
+	{{- if .IEEE80211W}}
+	ieee80211w={{.IEEE80211W}}
+	{{- end}}
+	{{- if .PSK}}
+	psk={{.PSK}}
+	{{- end}}
+	{{- if .SAEPassword}}
+	sae_password={{quote .SAEPassword}}
+	{{- end}}
+	{{- with .EAP}}
+	eap={{.Method}}
+	identity={{str .Identity}}
+	{{- if .AnonymousIdentity}}
+	anonymous_identity={{str .AnonymousIdentity}}
+	{{- end}}
+	{{- if .Password}}
+	password={{quote .Password}}
+	{{- end}}
+	{{- if .Phase2}}
+	phase2="{{.Phase2}}"
+	{{- end}}
+	{{- if .CACert}}
+	ca_cert={{quote .CACert}}
+	{{- end}}
+	{{- if .ClientCert}}
+	client_cert={{quote .ClientCert}}
+	{{- end}}
+	{{- if .PrivateKey}}
+	private_key={{quote .PrivateKey}}
+	{{- end}}
+	{{- if .PrivateKeyPassword}}
+	private_key_passwd={{quote .PrivateKeyPassword}}
+	{{- end}}
+	{{- end}}
+	{{- if .Priority}}
+	priority={{.Priority}}
+	{{- end}}
+}
+{{end}}`))
+
+//NewWPANetwork returns a network block for the saved network n.
+//
+//For wpa-psk the passphrase can either be an ASCII passphrase of 8 to 63
+//characters or a raw PSK of 64 hex digits.
+func NewWPANetwork(n *WifiNetwork) (*WPANetwork, error) {
+	if n.SSID == "" {
+		return nil, errors.New("fconf: missing ssid")
+	}
+	w := &WPANetwork{
+		SSID:     n.SSID,
+		Priority: n.Priority,
+		ScanSSID: n.Hidden,
+	}
+	if n.BSSID != "" {
+		mac, err := net.ParseMAC(n.BSSID)
+		if err != nil {
+			return nil, fmt.Errorf("fconf: bad bssid for %s %v", n.SSID, err)
+		}
+		w.BSSID = mac.String()
+	}
+	switch n.SecurityMode() {
+	case SecurityOpen:
+		w.KeyMgmt = "NONE"
+	case SecurityWPAPSK:
+		psk, err := WPAPSK(n.SSID, n.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		w.PSK = psk
+	case SecuritySAE:
+		if n.Passphrase == "" {
+			return nil, fmt.Errorf("fconf: missing passphrase for %s", n.SSID)
+		}
+		if strings.ContainsAny(n.Passphrase, "\r\n") {
+			return nil, fmt.Errorf("fconf: bad passphrase for %s", n.SSID)
+		}
+		w.KeyMgmt = "SAE"
+		w.IEEE80211W = 2
+		w.SAEPassword = n.Passphrase
+	case SecurityWPAEAP:
+		e, err := newWPAEAP(n.EAP)
+		if err != nil {
+			return nil, fmt.Errorf("fconf: %s %v", n.SSID, err)
+		}
+		w.KeyMgmt = "WPA-EAP"
+		w.EAP = e
+	default:
+		return nil, fmt.Errorf("fconf: unknown security %s", n.Security)
+	}
+	return w, nil
+}
+
+func newWPAEAP(e *WifiEAP) (*WPAEAP, error) {
+	if e == nil {
+		return nil, errors.New("missing eap configuration")
+	}
+	w := &WPAEAP{
+		Method:             strings.ToUpper(e.Method),
+		Identity:           e.Identity,
+		AnonymousIdentity:  e.AnonymousIdentity,
+		Password:           e.Password,
+		CACert:             e.CACert,
+		ClientCert:         e.ClientCert,
+		PrivateKey:         e.PrivateKey,
+		PrivateKeyPassword: e.PrivateKeyPassword,
+	}
+	if w.Identity == "" {
+		return nil, errors.New("missing eap identity")
+	}
+	for _, v := range []string{
+		w.Password, w.CACert, w.ClientCert, w.PrivateKey, w.PrivateKeyPassword,
+	} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errors.New("eap settings can not contain line breaks")
+		}
+	}
+	switch w.Method {
+	case "PEAP", "TTLS":
+		if w.Password == "" {
+			return nil, errors.New("missing eap password")
+		}
+		phase2 := strings.ToUpper(e.Phase2)
+		switch phase2 {
+		case "":
+			phase2 = "MSCHAPV2"
+		case "MSCHAPV2", "PAP", "CHAP", "GTC", "MD5":
+		default:
+			return nil, fmt.Errorf("unknown eap phase2 method %s", e.Phase2)
+		}
+		w.Phase2 = "auth=" + phase2
+	case "TLS":
+		if w.ClientCert == "" || w.PrivateKey == "" {
+			return nil, errors.New("eap tls needs client_cert and private_key")
+		}
+	default:
+		return nil, fmt.Errorf("unknown eap method %s", e.Method)
+	}
+	return w, nil
+}
+
+//WPAPSK derives the hex encoded pre shared key from the passphrase and ssid
+//the same way wpa_passphrase does (PBKDF2-SHA1, 4096 iterations, 32 bytes).
+func WPAPSK(ssid, passphrase string) (string, error) {
+	if len(passphrase) == 64 && isHex(passphrase) {
+		return strings.ToLower(passphrase), nil
+	}
+	if len(passphrase) < 8 || len(passphrase) > 63 {
+		return "", errors.New("fconf: passphrase must be 8..63 characters")
+	}
+	for _, c := range passphrase {
+		if c < 32 || c > 126 {
+			return "", errors.New("fconf: passphrase must be printable ASCII")
+		}
+	}
+	k := pbkdf2.Key([]byte(passphrase), []byte(ssid), 4096, 32, sha1.New)
+	return hex.EncodeToString(k), nil
+}
+
+//WriteTo writes wpa_supplicant configuration to dst.
+func (w *WPASupplicant) WriteTo(dst io.Writer) (int64, error) {
+	ctx := *w
+	if ctx.CtrlInterface == "" {
+		ctx.CtrlInterface = wpaCtrlInterface
+	}
+	var buf bytes.Buffer
+	err := wpaTpl.Execute(&buf, ctx)
+	if err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(dst)
+}
+
+// wpaString returns s the way wpa_supplicant expects string values like ssid
+// and identity. Printable strings are quoted, anything else is written as hex.
+func wpaString(s string) string {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 32 || c > 126 || c == '"' || c == '\\' {
+			return hex.EncodeToString([]byte(s))
+		}
+	}
+	return `"` + s + `"`
+}
+
+// wpaQuote quotes s for values like password which wpa_supplicant does not
+// accept in hex. wpa_supplicant takes everything up to the last quote so s
+// can contain quotes, but not line breaks.
+func wpaQuote(s string) string {
+	return `"` + s + `"`
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWPAPSK(t *testing.T) {
+	sample := []struct {
+		ssid, passphrase, psk string
+	}{
+		// IEEE 802.11i-2004 Annex H.4 test vectors
+		{"IEEE", "password", "f42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e"},
+		{"ThisIsASSID", "ThisIsAPassword", "0dc0d6eb90555ed6419756b9a15ec3e3209b63df707dd508d14581f8982721af"},
+	}
+	for _, v := range sample {
+		psk, err := WPAPSK(v.ssid, v.passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if psk != v.psk {
+			t.Errorf("expected %s got %s", v.psk, psk)
+		}
+	}
+	_, err := WPAPSK("voxbox", "short")
+	if err == nil {
+		t.Error("expected an error for short passphrase")
+	}
+}
+
+func TestWifiConfig(t *testing.T) {
+	w := &Wifi{
+		Username: "voxbox",
+		Password: "voxbox99",
+		Country:  "ke",
+	}
+	var buf bytes.Buffer
+	err := wifiConfig(w, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ioutil.ReadFile("fixture/wpa_supplicant.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, buf.Bytes()) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), buf.String())
+	}
+}
+
+func TestWifiConfig_networks(t *testing.T) {
+	w := &Wifi{
+		Username: "voxbox",
+		Password: "voxbox99",
+	}
+	w.AddNetwork(&WifiNetwork{
+		SSID:       "partner office",
+		Passphrase: "partner-secret",
+		Priority:   5,
+		BSSID:      "AA:BB:CC:DD:EE:FF",
+	})
+	w.AddNetwork(&WifiNetwork{
+		SSID:       "staff phone",
+		Passphrase: "hotspot123",
+		Priority:   1,
+		Hidden:     true,
+	})
+	var buf bytes.Buffer
+	err := wifiConfig(w, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ioutil.ReadFile("fixture/wpa_supplicant_networks.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, buf.Bytes()) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), buf.String())
+	}
+
+	if !w.RemoveNetwork("voxbox") {
+		t.Fatal("expected voxbox to be removed")
+	}
+	if w.RemoveNetwork("voxbox") {
+		t.Error("expected voxbox to be already removed")
+	}
+	n := w.SavedNetworks()
+	if len(n) != 2 {
+		t.Fatalf("expected 2 networks got %d", len(n))
+	}
+}
+
+func TestWifiConfig_security(t *testing.T) {
+	w := &Wifi{}
+	w.AddNetwork(&WifiNetwork{
+		SSID:     "eduroam",
+		Priority: 10,
+		EAP: &WifiEAP{
+			Method:            "peap",
+			Identity:          "jdoe@example.ac.ke",
+			AnonymousIdentity: "anonymous@example.ac.ke",
+			Password:          `p"ss&<word`,
+			CACert:            "/etc/wpa_supplicant/fconf-certs/wlan0/eduroam-ca.pem",
+		},
+	})
+	w.AddNetwork(&WifiNetwork{
+		SSID:     "ngo",
+		Security: SecurityWPAEAP,
+		EAP: &WifiEAP{
+			Method:     "tls",
+			Identity:   "voxbox",
+			ClientCert: "/etc/wpa_supplicant/fconf-certs/wlan0/ngo-client.pem",
+			PrivateKey: "/etc/wpa_supplicant/fconf-certs/wlan0/ngo-key.pem",
+		},
+	})
+	w.AddNetwork(&WifiNetwork{
+		SSID:       "studio",
+		Security:   SecuritySAE,
+		Passphrase: "wpa3 passphrase",
+	})
+	w.AddNetwork(&WifiNetwork{
+		SSID: "market",
+	})
+	var buf bytes.Buffer
+	err := wifiConfig(w, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ioutil.ReadFile("fixture/wpa_supplicant_security.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, buf.Bytes()) {
+		t.Errorf("expected \n %s \n Got \n %s", string(exp), buf.String())
+	}
+
+	w = &Wifi{Username: "eduroam", EAP: &WifiEAP{Method: "ttls", Identity: "jdoe"}}
+	err = wifiConfig(w, &buf)
+	if err == nil {
+		t.Error("expected an error for missing eap password")
+	}
+
+	w = &Wifi{Username: "eduroam", EAP: &WifiEAP{Method: "peap", Identity: "jdoe",
+		Password: "secret", Phase2: "mschapv2\"\n\tpriority=100\n\tphase2=\"auth=PAP"}}
+	err = wifiConfig(w, &buf)
+	if err == nil {
+		t.Error("expected an error for unknown eap phase2 method")
+	}
+
+	w = &Wifi{Username: "market", Password: "market99", Country: "ke\nupdate_config=1"}
+	err = wifiConfig(w, &buf)
+	if err == nil {
+		t.Error("expected an error for a bad country")
+	}
+}
+
+func TestCopyWifiCerts(t *testing.T) {
+	src, err := ioutil.TempDir("", "fconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	ca := filepath.Join(src, "ca.pem")
+	err = ioutil.WriteFile(ca, []byte("ca"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(src, "certs")
+	n := &WifiNetwork{
+		SSID: "my uni/wifi",
+		EAP:  &WifiEAP{Method: "peap", CACert: ca},
+	}
+	err = copyWifiCerts(dir, []*WifiNetwork{n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := filepath.Join(dir, "my_uni_wifi-ca.pem")
+	if n.EAP.CACert != e {
+		t.Errorf("expected %s got %s", e, n.EAP.CACert)
+	}
+	b, err := ioutil.ReadFile(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ca" {
+		t.Errorf("expected ca got %s", b)
+	}
+
+	// copying again is a no op
+	err = copyWifiCerts(dir, []*WifiNetwork{n})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/urfave/cli"
+)
+
+// 4g modem modes. In ndis mode the modem connects on its own and shows up as
+// an ethernet device, qmi and mbim modems need fconf to start a data session.
+const (
+	ModeNDIS = "ndis"
+	ModeQMI  = "qmi"
+	ModeMBIM = "mbim"
+)
+
+const (
+	wwanService       = "fconf-wwan-%s.service"
+	defaultWWANDevice = "/dev/cdc-wdm0"
+
+	// wwanMetric is the metric systemd-networkd gives to dhcp default routes,
+	// used for the route of mbim sessions which have no dhcp.
+	wwanMetric = 1024
+)
+
+// wwancli runs qmicli or mbimcli and returns its output. It is a variable so
+// tests can replace the modem with canned output.
+var wwancli = func(name string, args ...string) (string, error) {
+	o, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("fconf: %s %s %v %s", name, strings.Join(args, " "), err, o)
+	}
+	return string(o), nil
+}
+
+// wwanSession is the qmi data session of a modem, needed to stop it.
+type wwanSession struct {
+	Handle string `json:"handle"`
+	CID    string `json:"cid"`
+}
+
+// validateMode checks the qmi and mbim settings of the modem.
+func (f *FourG) validateMode() error {
+	switch f.Mode {
+	case "", ModeNDIS:
+		return nil
+	case ModeQMI, ModeMBIM:
+	default:
+		return fmt.Errorf("fconf: unknown mode %s, use ndis, qmi or mbim", f.Mode)
+	}
+	if f.Backend == BackendModemManager {
+		return errors.New("fconf: ModemManager sets up qmi and mbim modems itself, remove mode")
+	}
+	if f.APN == "" {
+		return fmt.Errorf("fconf: %s mode needs an apn", f.Mode)
+	}
+	switch f.IPType {
+	case "", "ipv4", "ipv6":
+	case "ipv4v6":
+		if f.Mode == ModeQMI {
+			return errors.New("fconf: qmi mode supports ipv4 or ipv6, not both")
+		}
+	default:
+		return fmt.Errorf("fconf: unknown ip_type %s", f.IPType)
+	}
+	switch f.Auth {
+	case "", "none", "pap", "chap":
+	case "both":
+		if f.Mode == ModeMBIM {
+			return errors.New("fconf: mbim mode supports pap or chap auth, not both")
+		}
+	default:
+		return fmt.Errorf("fconf: unknown auth %s", f.Auth)
+	}
+	if f.Mode == ModeMBIM && f.DHCP {
+		return errors.New("fconf: mbim modems have no dhcp, fconf sets the address of the session")
+	}
+	return nil
+}
+
+func (f *FourG) wwanDevice() string {
+	if f.Device != "" {
+		return f.Device
+	}
+	return defaultWWANDevice
+}
+
+func (f *FourG) ipType() string {
+	if f.IPType == "" {
+		return "ipv4"
+	}
+	return f.IPType
+}
+
+// qmiSettings returns the settings of --wds-start-network.
+func (f *FourG) qmiSettings() string {
+	s := []string{"apn=" + mmQuote(f.APN)}
+	if f.ipType() == "ipv6" {
+		s = append(s, "ip-type=6")
+	} else {
+		s = append(s, "ip-type=4")
+	}
+	if f.Auth != "" {
+		s = append(s, "auth="+strings.ToUpper(f.Auth))
+	}
+	if f.Username != "" {
+		s = append(s, "username="+mmQuote(f.Username))
+	}
+	if f.Password != "" {
+		s = append(s, "password="+mmQuote(f.Password))
+	}
+	return strings.Join(s, ",")
+}
+
+// mbimSettings returns the settings of --connect.
+func (f *FourG) mbimSettings() string {
+	s := []string{"apn=" + mmQuote(f.APN), "ip-type=" + f.ipType()}
+	if f.Auth != "" && f.Auth != "none" {
+		s = append(s, "auth="+strings.ToUpper(f.Auth))
+	}
+	if f.Username != "" {
+		s = append(s, "username="+mmQuote(f.Username))
+	}
+	if f.Password != "" {
+		s = append(s, "password="+mmQuote(f.Password))
+	}
+	return strings.Join(s, ",")
+}
+
+// cliValue returns the first quoted value of the field name in the output of
+// qmicli or mbimcli, which look like
+//
+//	Packet data handle: '2264924816'
+func cliValue(o, name string) string {
+	m := regexp.MustCompile(regexp.QuoteMeta(name) + `: '([^']*)'`).FindStringSubmatch(o)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// unlockPIN returns an error when only one attempt is left, so that a wrong
+// PIN does not lock the SIM with its PUK, or when the SIM of interface i
+// rejected the PIN before.
+func unlockPIN(i, pin, retries string) error {
+	if pinRejected(i) {
+		return errPINRejected
+	}
+	if pin == "" {
+		return errNoPIN
+	}
+	if n, err := strconv.Atoi(retries); err == nil && n == 1 {
+		return errors.New("fconf: one PIN attempt left, enter the PIN by hand")
+	}
+	return nil
+}
+
+// verifyPIN runs the command that enters the PIN of the SIM of interface i and
+// marks the PIN as rejected when it fails.
+func verifyPIN(i, name string, args ...string) error {
+	_, err := wwancli(name, args...)
+	if err != nil {
+		log.Printf("wwan: %v", err)
+		rerr := rejectPIN(i)
+		if rerr != nil {
+			return rerr
+		}
+		return errPINRejected
+	}
+	return nil
+}
+
+// qmiConnect unlocks the SIM when it needs pin and starts a data session.
+func (f *FourG) qmiConnect(pin string) (*wwanSession, error) {
+	dev := f.wwanDevice()
+	o, err := wwancli("qmicli", "-p", "-d", dev, "--uim-get-card-status")
+	if err != nil {
+		return nil, err
+	}
+	if cliValue(o, "PIN1 state") == "enabled-not-verified" {
+		err = unlockPIN(f.Interface, pin, cliValue(o, "PIN1 retries"))
+		if err != nil {
+			return nil, err
+		}
+		err = verifyPIN(f.Interface, "qmicli", "-p", "-d", dev, "--uim-verify-pin=PIN1,"+pin)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = qmiRawIP(f.Interface)
+	if err != nil {
+		return nil, err
+	}
+	o, err = wwancli("qmicli", "-p", "-d", dev,
+		"--device-open-net=net-raw-ip|net-no-qos-header",
+		"--wds-start-network="+f.qmiSettings(),
+		"--client-no-release-cid")
+	if err != nil {
+		return nil, err
+	}
+	s := &wwanSession{
+		Handle: cliValue(o, "Packet data handle"),
+		CID:    cliValue(o, "CID"),
+	}
+	if s.Handle == "" || s.CID == "" {
+		return nil, fmt.Errorf("fconf: unexpected qmicli output %s", o)
+	}
+	return s, nil
+}
+
+// qmiRawIP switches the qmi interface i to raw ip, which is what the modems
+// we use speak. The interface must be down to change it.
+func qmiRawIP(i string) error {
+	name := filepath.Join("/sys/class/net", i, "qmi/raw_ip")
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(string(b)) == "Y" {
+		return nil
+	}
+	o, err := exec.Command("ip", "link", "set", i, "down").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fconf: ip link set %s down %v %s", i, err, o)
+	}
+	return ioutil.WriteFile(name, []byte("Y"), 0644)
+}
+
+// qmiDisconnect stops the data session s.
+func (f *FourG) qmiDisconnect(s *wwanSession) error {
+	_, err := wwancli("qmicli", "-p", "-d", f.wwanDevice(),
+		"--wds-stop-network="+s.Handle, "--client-cid="+s.CID)
+	return err
+}
+
+// mbimConnect unlocks the SIM when it needs pin, connects and returns the ip
+// settings of the session.
+func (f *FourG) mbimConnect(pin string) (*MMBearer, error) {
+	dev := f.wwanDevice()
+	o, err := wwancli("mbimcli", "-p", "-d", dev, "--query-pin-state")
+	if err != nil {
+		return nil, err
+	}
+	if cliValue(o, "Pin Type") == "pin1" && cliValue(o, "Pin State") == "locked" {
+		err = unlockPIN(f.Interface, pin, cliValue(o, "Remaining attempts"))
+		if err != nil {
+			return nil, err
+		}
+		err = verifyPIN(f.Interface, "mbimcli", "-p", "-d", dev, "--enter-pin="+pin)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = wwancli("mbimcli", "-p", "-d", dev, "--attach-packet-service")
+	if err != nil {
+		return nil, err
+	}
+	o, err = wwancli("mbimcli", "-p", "-d", dev, "--connect="+f.mbimSettings())
+	if err != nil {
+		return nil, err
+	}
+	return parseMBIMConnect(o, f.Interface)
+}
+
+// parseMBIMConnect reads the ipv4 settings in the output of mbimcli --connect.
+func parseMBIMConnect(o, i string) (*MMBearer, error) {
+	b := &MMBearer{Connected: true, Interface: i, Method: "static"}
+	addr := cliValue(o, "IP [0]")
+	if addr == "" {
+		return nil, fmt.Errorf("fconf: no ipv4 address in mbimcli output %s", o)
+	}
+	p := strings.Split(addr, "/")
+	b.Address = p[0]
+	b.Prefix = 32
+	if len(p) == 2 {
+		n, err := strconv.Atoi(p[1])
+		if err != nil {
+			return nil, fmt.Errorf("fconf: bad address %s", addr)
+		}
+		b.Prefix = n
+	}
+	b.Gateway = cliValue(o, "Gateway")
+	for n := 0; ; n++ {
+		d := cliValue(o, fmt.Sprintf("DNS [%d]", n))
+		if d == "" {
+			break
+		}
+		b.DNS = append(b.DNS, d)
+	}
+	return b, nil
+}
+
+// mbimDisconnect disconnects the session of the modem.
+func (f *FourG) mbimDisconnect() error {
+	_, err := wwancli("mbimcli", "-p", "-d", f.wwanDevice(), "--disconnect")
+	return err
+}
+
+// wwanConnect starts the data session of the 4g modem on interface i. The qmi
+// session is saved to stop it later.
+func wwanConnect(i string, f *FourG) error {
+	pin, err := readPIN(i)
+	if err != nil {
+		return err
+	}
+	switch f.Mode {
+	case ModeQMI:
+		s, err := f.qmiConnect(pin)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		err = keepState(fmt.Sprintf(defaultWWANState, i), b)
+		if err != nil {
+			return err
+		}
+		o, err := exec.Command("ip", "link", "set", i, "up").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("fconf: ip link set %s up %v %s", i, err, o)
+		}
+	case ModeMBIM:
+		b, err := f.mbimConnect(pin)
+		if err != nil {
+			return err
+		}
+		err = applyBearer(b, wwanMetric, true)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("fconf: %s is not a qmi or mbim modem", i)
+	}
+	fmt.Printf("connected %s to %s\n", i, f.APN)
+	return nil
+}
+
+// wwanDisconnect stops the data session of the 4g modem on interface i.
+func wwanDisconnect(i string, f *FourG) error {
+	switch f.Mode {
+	case ModeQMI:
+		name := filepath.Join(stateDir(), fmt.Sprintf(defaultWWANState, i))
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		s := &wwanSession{}
+		err = json.Unmarshal(b, s)
+		if err != nil {
+			return err
+		}
+		err = f.qmiDisconnect(s)
+		if err != nil {
+			return err
+		}
+		return removeFile(name)
+	case ModeMBIM:
+		return f.mbimDisconnect()
+	}
+	return nil
+}
+
+// wwanUnit is the systemd service that starts the data session of a qmi or
+// mbim modem. It is not ordered before systemd-networkd, which configures the
+// interface once the session is up, so a modem that is slow to register does
+// not hold the network of the device back. systemd restarts it until the
+// modem connects.
+type wwanUnit struct {
+	key string
+	bin string
+}
+
+//ToSystemdUnit implement UnitFile interface
+func (w wwanUnit) ToSystemdUnit() ([]*unit.UnitOption, error) {
+	if w.key == "" {
+		return nil, errors.New("fconf: missing interface")
+	}
+	run := w.bin + " --interface " + w.key + " 4g-ndis"
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "Description", "fconf data session of "+w.key),
+		unit.NewUnitOption("Service", "Type", "simple"),
+		unit.NewUnitOption("Service", "RemainAfterExit", "yes"),
+		unit.NewUnitOption("Service", "ExecStart", run+" --"+wwanConnectFlag),
+		unit.NewUnitOption("Service", "ExecStop", run+" --"+wwanDisconnectFlag),
+		unit.NewUnitOption("Service", "Restart", "on-failure"),
+		unit.NewUnitOption("Service", "RestartSec", "30"),
+		unit.NewUnitOption("Install", "WantedBy", "multi-user.target"),
+	}, nil
+}
+
+// writeWWANService writes the data session service of the 4g modem on
+// interface i.
+func writeWWANService(i string) error {
+	err := CreateSystemdFile(wwanUnit{i, fconfBin()},
+		filepath.Join(systemdBase, fmt.Sprintf(wwanService, i)), 0644)
+	if err != nil {
+		return err
+	}
+	return reloadSystemd()
+}
+
+// wwanCMD starts or stops the data session of the 4g modem on interface i.
+func wwanCMD(ctx *cli.Context, i string, f *FourG) error {
+	if ctx.IsSet(wwanDisconnectFlag) {
+		return wwanDisconnect(i, f)
+	}
+	// the service is restarted when connecting fails.
+	return wwanConnect(i, f)
+}
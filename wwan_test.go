@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// fakeWWANCLI answers qmicli and mbimcli commands with the fixture in
+// fixture/wwan named by replies, other commands get no output. All commands
+// are recorded.
+func fakeWWANCLI(calls *[]string, replies map[string]string) func() {
+	orig := wwancli
+	wwancli = func(name string, args ...string) (string, error) {
+		cmd := name + " " + strings.Join(args, " ")
+		*calls = append(*calls, cmd)
+		for k, v := range replies {
+			if strings.Contains(cmd, k) {
+				b, err := ioutil.ReadFile("fixture/wwan/" + v)
+				return string(b), err
+			}
+		}
+		return "", nil
+	}
+	return func() {
+		wwancli = orig
+	}
+}
+
+func TestQMIConnect(t *testing.T) {
+	testStateDir(t)
+
+	var calls []string
+	defer fakeWWANCLI(&calls, map[string]string{
+		"--uim-get-card-status": "qmi-card-status.txt",
+		"--wds-start-network":   "qmi-start-network.txt",
+	})()
+	f := &FourG{Network: Network{Interface: "wwan-fconf-test"},
+		Mode: ModeQMI, APN: "internet", Auth: "chap", Username: "web", Password: "a b"}
+	s, err := f.qmiConnect("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Handle != "2264924816" || s.CID != "20" {
+		t.Errorf("unexpected session %+v", s)
+	}
+	e := []string{
+		"qmicli -p -d /dev/cdc-wdm0 --uim-get-card-status",
+		"qmicli -p -d /dev/cdc-wdm0 --uim-verify-pin=PIN1,1234",
+		"qmicli -p -d /dev/cdc-wdm0 --device-open-net=net-raw-ip|net-no-qos-header " +
+			"--wds-start-network=apn=internet,ip-type=4,auth=CHAP,username=web,password='a b' --client-no-release-cid",
+	}
+	if !reflect.DeepEqual(calls, e) {
+		t.Errorf("expected %q got %q", e, calls)
+	}
+
+	calls = nil
+	err = f.qmiDisconnect(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = []string{"qmicli -p -d /dev/cdc-wdm0 --wds-stop-network=2264924816 --client-cid=20"}
+	if !reflect.DeepEqual(calls, e) {
+		t.Errorf("expected %q got %q", e, calls)
+	}
+
+	_, err = f.qmiConnect("")
+	if err == nil {
+		t.Error("expected an error without a pin")
+	}
+}
+
+func TestQMIRejectedPIN(t *testing.T) {
+	testStateDir(t)
+
+	var calls []string
+	defer fakeWWANCLI(&calls, map[string]string{
+		"--uim-get-card-status": "qmi-card-status.txt",
+	})()
+	fake := wwancli
+	wwancli = func(name string, args ...string) (string, error) {
+		o, err := fake(name, args...)
+		if strings.HasPrefix(args[len(args)-1], "--uim-verify-pin") {
+			return "", errors.New("fconf: qmicli incorrect password")
+		}
+		return o, err
+	}
+	f := &FourG{Network: Network{Interface: "wwan0"}, Mode: ModeQMI}
+	for n := 0; n < 2; n++ {
+		_, err := f.qmiConnect("1234")
+		if err != errPINRejected {
+			t.Fatalf("%d: expected %v got %v", n, errPINRejected, err)
+		}
+	}
+	var pins int
+	for _, c := range calls {
+		if strings.Contains(c, "--uim-verify-pin") {
+			pins++
+		}
+	}
+	if pins != 1 {
+		t.Errorf("expected the PIN to be entered once got %q", calls)
+	}
+}
+
+func TestMBIMConnect(t *testing.T) {
+	var calls []string
+	defer fakeWWANCLI(&calls, map[string]string{
+		"--connect": "mbim-connect.txt",
+	})()
+	f := &FourG{Network: Network{Interface: "wwan0"},
+		Mode: ModeMBIM, Device: "/dev/cdc-wdm1", APN: "internet", IPType: "ipv4v6"}
+	b, err := f.mbimConnect("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &MMBearer{
+		Connected: true,
+		Interface: "wwan0",
+		Method:    "static",
+		Address:   "10.134.203.177",
+		Prefix:    30,
+		Gateway:   "10.134.203.178",
+		DNS:       []string{"10.53.8.32", "10.53.8.33"},
+	}
+	if !reflect.DeepEqual(b, e) {
+		t.Errorf("expected %+v got %+v", e, b)
+	}
+	c := []string{
+		"mbimcli -p -d /dev/cdc-wdm1 --query-pin-state",
+		"mbimcli -p -d /dev/cdc-wdm1 --attach-packet-service",
+		"mbimcli -p -d /dev/cdc-wdm1 --connect=apn=internet,ip-type=ipv4v6",
+	}
+	if !reflect.DeepEqual(calls, c) {
+		t.Errorf("expected %q got %q", c, calls)
+	}
+}
+
+func TestValidateMode(t *testing.T) {
+	sample := []struct {
+		f     FourG
+		valid bool
+	}{
+		{FourG{}, true},
+		{FourG{Mode: ModeQMI, APN: "internet"}, true},
+		{FourG{Mode: "rndis"}, false},
+		{FourG{Mode: ModeQMI}, false},
+		{FourG{Mode: ModeQMI, APN: "internet", IPType: "ipv4v6"}, false},
+		{FourG{Mode: ModeQMI, APN: "internet", Backend: BackendModemManager}, false},
+		{FourG{Mode: ModeMBIM, APN: "internet", Auth: "both"}, false},
+		{FourG{Network: Network{DHCP: true}, Mode: ModeMBIM, APN: "internet"}, false},
+	}
+	for _, v := range sample {
+		err := v.f.validateMode()
+		if (err == nil) != v.valid {
+			t.Errorf("%+v: expected valid %v got %v", v.f, v.valid, err)
+		}
+	}
+}
+
+func TestWWANUnit(t *testing.T) {
+	u, err := wwanUnit{"wwan0", "/usr/local/bin/fconf"}.ToSystemdUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(unit.Serialize(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ioutil.ReadFile("fixture/fconf-wwan.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, e) {
+		t.Errorf("expected \n %s \n Got \n %s", e, b)
+	}
+}